@@ -8,7 +8,22 @@ import (
 	"github.com/vidman22/epub-parser/internal"
 )
 
+// Options and the resolver types live in the internal package, the aliases let callers configure parsing
+type (
+	Options               = parser.Options
+	LanguageResolver      = parser.LanguageResolver
+	TableLanguageResolver = parser.TableLanguageResolver
+	GenreMapper           = parser.GenreMapper
+	TableGenreMapper      = parser.TableGenreMapper
+	RenditionSelector     = parser.RenditionSelector
+	SanitizePolicy        = parser.SanitizePolicy
+)
+
 func ParseEpub(path string) (*parser.ParsedBookResult, error) {
+	return ParseEpubWithOptions(path, Options{})
+}
+
+func ParseEpubWithOptions(path string, opts Options) (*parser.ParsedBookResult, error) {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		return nil, err
@@ -20,7 +35,7 @@ func ParseEpub(path string) (*parser.ParsedBookResult, error) {
 	}
 	defer r.Close()
 
-	res, err := parser.OpenBook(r)
+	res, err := parser.OpenBookWithOptions(r, opts)
 
 	if err != nil {
 		return nil, err
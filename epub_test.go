@@ -1,6 +1,10 @@
 package epub
 
 import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	parser "github.com/vidman22/epub-parser/internal"
@@ -99,12 +103,23 @@ func assertv3Titles(t *testing.T, titles []string) {
 func assertMetadata(t *testing.T, metaData *parser.ResultMetadata) {
 	assertEquals("mainId", t, metaData.MainId, "//www.gutenberg.org/43", "http://www.gutenberg.org/43")
 	assertEquals("title", t, metaData.Title, "The Strange Case of Dr. Jekyll and Mr. Hyde")
+	assertEquals("sortTitle", t, metaData.SortTitle, "Strange Case of Dr. Jekyll and Mr. Hyde, The")
+	assertEquals("subtitle", t, metaData.Subtitle, "")
 	assertEquals("identifier", t, metaData.Identifier, "//www.gutenberg.org/43", "http://www.gutenberg.org/43")
 	assertEquals("language", t, metaData.Language, "en")
+	if metaData.LanguageDetection == nil || metaData.LanguageDetection.Detected != "en" || metaData.LanguageDetection.Mismatch {
+		t.Log("'languageDetection' expected en without a mismatch")
+		t.Fail()
+	}
+	if metaData.LanguageID != parser.DefaultLanguageIDs["en"] {
+		t.Logf("'languageID' expected %d but is %d", parser.DefaultLanguageIDs["en"], metaData.LanguageID)
+		t.Fail()
+	}
 	assertEquals("creator", t, metaData.Creator, "Robert Louis Stevenson")
 	assertEquals("contributor", t, metaData.Contributor, "")
 	assertEquals("publisher", t, metaData.Publisher, "")
 	assertEquals("subject", t, metaData.Subject, "Science fiction")
+	assertEquals("genre", t, metaData.Genre, parser.GenreScienceFiction)
 	assertEquals("description", t, metaData.Description, "")
 	assertEquals("date", t, metaData.Date, "2008-06-27")
 }
@@ -121,3 +136,287 @@ func assertEquals(fieldName string, t *testing.T, actuallyValue string, expected
 		}
 	}
 }
+
+func Test_manifest_properties(t *testing.T) {
+	book, err := ParseEpub("./fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	properties := make(map[string]string)
+	for _, item := range book.Manifest {
+		for _, p := range item.Properties {
+			properties[p] = item.Href
+		}
+	}
+	assertEquals("nav", t, properties[parser.PropertyNav], "OEBPS/toc.xhtml")
+	assertEquals("cover-image", t, properties[parser.PropertyCoverImage], "OEBPS/7337271621053197105_cover.jpg")
+	assertEquals("svg", t, properties[parser.PropertySVG], "OEBPS/wrap0000.xhtml")
+	assertEquals("cover", t, book.Metadata.Cover.FileName, "7337271621053197105_cover.jpg")
+
+	v2, err := ParseEpub("./fixtures/drjekyllmrhyde_v2.epub")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assertEquals("cover v2", t, v2.Metadata.Cover.FileName, "7337271621053197105_cover.jpg")
+}
+
+func Test_image_pages_text_book(t *testing.T) {
+	book, err := ParseEpubWithOptions("./fixtures/drjekyllmrhyde_v3.epub", Options{ImagePages: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if book.Metadata.ImageOnly || book.Pages != nil || len(book.Texts) == 0 {
+		t.Logf("text book expected as chapters but is image only %v with %d pages", book.Metadata.ImageOnly, len(book.Pages))
+		t.Fail()
+	}
+}
+
+// testFile is a file of an archive writeTestEpub builds
+type testFile struct{ name, data string }
+
+// writeTestEpub writes files to an EPUB in a temporary directory and returns its path
+func writeTestEpub(t *testing.T, files []testFile) string {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, file := range files {
+		fw, err := w.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(file.data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_image_pages(t *testing.T) {
+	files := []testFile{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:uuid:image-pages</dc:identifier><dc:title>Pages</dc:title><dc:language>ja</dc:language>
+<meta property="rendition:layout">pre-paginated</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="p1" href="p1.xhtml" media-type="application/xhtml+xml"/>
+<item id="p2" href="p2.xhtml" media-type="application/xhtml+xml" properties="svg"/>
+<item id="p3" href="images/p3.jpg" media-type="image/jpeg"/>
+<item id="i1" href="images/p1.jpg" media-type="image/jpeg"/>
+<item id="i2" href="images/p2.png" media-type="image/png"/>
+</manifest>
+<spine page-progression-direction="rtl">
+<itemref idref="p1" properties="page-spread-right"/>
+<itemref idref="p2" properties="page-spread-left"/>
+<itemref idref="p3" properties="rendition:page-spread-center"/>
+</spine></package>`},
+		{"OEBPS/nav.xhtml", `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><head><title>Pages</title></head>
+<body><nav epub:type="toc"><ol><li><a href="p1.xhtml">Start</a></li></ol></nav></body></html>`},
+		{"OEBPS/p1.xhtml", `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>1</title><meta name="viewport" content="width=800, height=1200"/></head>
+<body><div><img src="images/p1.jpg" alt=""/></div></body></html>`},
+		{"OEBPS/p2.xhtml", `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>2</title></head><body>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 900 1300">
+<image width="900" height="1300" xlink:href="images/p2.png"/></svg></body></html>`},
+		{"OEBPS/images/p1.jpg", "first page"},
+		{"OEBPS/images/p2.png", "second page"},
+		{"OEBPS/images/p3.jpg", "third page"},
+	}
+	book, err := ParseEpubWithOptions(writeTestEpub(t, files), Options{ImagePages: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !book.Metadata.ImageOnly || book.Texts != nil {
+		t.Logf("expected an image only book without texts but is image only %v with %d texts", book.Metadata.ImageOnly, len(book.Texts))
+		t.Fail()
+	}
+	expected := []struct {
+		href, spread, file string
+		viewport           *parser.Viewport
+	}{
+		{"OEBPS/images/p1.jpg", "right", "first page", &parser.Viewport{Width: 800, Height: 1200}},
+		{"OEBPS/images/p2.png", "left", "second page", &parser.Viewport{Width: 900, Height: 1300}},
+		{"OEBPS/images/p3.jpg", "center", "third page", nil},
+	}
+	if len(book.Pages) != len(expected) {
+		t.Fatalf("expected %d pages but got %d", len(expected), len(book.Pages))
+	}
+	for i, page := range book.Pages {
+		assertEquals("page href", t, page.Href, expected[i].href)
+		assertEquals("page spread", t, page.PageSpread, expected[i].spread)
+		assertEquals("page file", t, string(page.File), expected[i].file)
+		if (page.Viewport == nil) != (expected[i].viewport == nil) || (page.Viewport != nil && *page.Viewport != *expected[i].viewport) {
+			t.Logf("page %d viewport expected %v but is %v", i, expected[i].viewport, page.Viewport)
+			t.Fail()
+		}
+	}
+}
+
+func Test_preserve_styles(t *testing.T) {
+	book, err := ParseEpubWithOptions("./fixtures/drjekyllmrhyde_v3.epub", Options{PreserveStyles: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if book.StyleScope == "" || !strings.Contains(book.Stylesheet, "."+book.StyleScope+" div.chapter {") {
+		t.Logf("expected the chapter rules scoped under %q in %s", book.StyleScope, book.Stylesheet)
+		t.Fail()
+	}
+	if !strings.Contains(book.Texts[2].Html, `<div class="chapter"`) {
+		t.Log("expected the chapter classes to be kept")
+		t.Fail()
+	}
+}
+
+func Test_hidden_content_modes(t *testing.T) {
+	path := writeTestEpub(t, []testFile{
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:uuid:hidden</dc:identifier><dc:title>Hidden</dc:title><dc:language>en</dc:language>
+<meta property="dcterms:modified">2024-01-01T00:00:00Z</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="c1" href="c1.xhtml" media-type="application/xhtml+xml"/>
+</manifest>
+<spine><itemref idref="c1"/></spine></package>`},
+		{"OEBPS/nav.xhtml", `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><head><title>Hidden</title></head>
+<body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li></ol></nav></body></html>`},
+		{"OEBPS/c1.xhtml", `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title>
+<style>.toggled { display: none }</style></head>
+<body><h1>One</h1><p>Text here.</p><p class="toggled">Shown when toggled.</p></body></html>`},
+	})
+
+	book, err := ParseEpubWithOptions(path, Options{SkipLanguageDetection: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Contains(book.Texts[0].Html, "when toggled") {
+		t.Logf("expected the hidden paragraph removed from the formatted chapter %s", book.Texts[0].Html)
+		t.Fail()
+	}
+
+	// the publisher's markup is kept as written, their stylesheet decides what shows
+	book, err = ParseEpubWithOptions(path, Options{SkipLanguageDetection: true, PreserveStyles: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(book.Texts[0].Html, `<p class="toggled">Shown when toggled.</p>`) {
+		t.Logf("expected the hidden paragraph kept with PreserveStyles in %s", book.Texts[0].Html)
+		t.Fail()
+	}
+}
+
+func Test_dublin_core_metadata(t *testing.T) {
+	for _, path := range []string{"./fixtures/drjekyllmrhyde_v2.epub", "./fixtures/drjekyllmrhyde_v3.epub"} {
+		book, err := ParseEpub(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		dc := book.Metadata.DublinCore
+
+		if len(dc.Subject) != 7 {
+			t.Logf("%s subjects expected 7 but is %d", path, len(dc.Subject))
+			t.Fail()
+		} else {
+			assertEquals("subject[6]", t, dc.Subject[6].Value, "Multiple personality -- Fiction")
+		}
+		if len(dc.Creator) != 1 || len(dc.Rights) != 1 || len(dc.Source) != 1 {
+			t.Logf("%s expected one creator, rights and source", path)
+			t.Fail()
+			continue
+		}
+		assertEquals("creator file-as", t, dc.Creator[0].FileAs, "Stevenson, Robert Louis")
+		assertEquals("rights", t, dc.Rights[0].Value, "Public domain in the USA.")
+		assertEquals("source", t, dc.Source[0].Value, "https://www.gutenberg.org/files/43/43-h/43-h.htm")
+	}
+
+	book, err := ParseEpub("./fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assertEquals("modified", t, book.Metadata.Modified, "2026-01-01T08:43:11Z")
+	assertEquals("title lang", t, book.Metadata.DublinCore.Title[0].Lang, "en")
+}
+
+func Test_people(t *testing.T) {
+	for _, path := range []string{"./fixtures/drjekyllmrhyde_v2.epub", "./fixtures/drjekyllmrhyde_v3.epub"} {
+		book, err := ParseEpub(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		authors := book.Metadata.PeopleWithRole(parser.RoleAuthor)
+		if len(authors) != 1 {
+			t.Logf("%s authors expected 1 but is %d", path, len(authors))
+			t.Fail()
+			continue
+		}
+		assertEquals("author name", t, authors[0].Name, "Robert Louis Stevenson")
+		assertEquals("author sort name", t, authors[0].SortName, "Stevenson, Robert Louis")
+		assertEquals("author role label", t, authors[0].RoleLabel, "author")
+	}
+}
+
+func Test_dates(t *testing.T) {
+	for _, path := range []string{"./fixtures/drjekyllmrhyde_v2.epub", "./fixtures/drjekyllmrhyde_v3.epub"} {
+		book, err := ParseEpub(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		md := book.Metadata
+		if md.PublicationYear == nil || *md.PublicationYear != 2008 {
+			t.Logf("%s publication year expected 2008", path)
+			t.Fail()
+		}
+		if md.PublicationDate == nil || md.PublicationDate.Precision != parser.DatePrecisionDay {
+			t.Logf("%s publication date expected with day precision", path)
+			t.Fail()
+		}
+	}
+
+	book, err := ParseEpub("./fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	modification := book.Metadata.ModificationDate
+	if modification == nil || modification.Precision != parser.DatePrecisionTime || modification.Time.Year() != 2026 {
+		t.Log("modification date expected from dcterms:modified")
+		t.Fail()
+	}
+}
+
+func Test_accessibility_metadata(t *testing.T) {
+	book, err := ParseEpub("./fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	a := book.Metadata.Accessibility
+	if len(a.AccessModes) != 1 || len(a.Features) != 1 || len(a.Hazards) != 1 || len(a.AccessModesSufficient) != 1 {
+		t.Logf("unexpected accessibility metadata %+v", a)
+		t.Fail()
+		return
+	}
+	assertEquals("accessMode", t, a.AccessModes[0], "textual")
+	assertEquals("accessModeSufficient", t, a.AccessModesSufficient[0], "textual,visual")
+	assertEquals("hazard", t, a.Hazards[0], "none")
+	assertEquals("summary", t, a.Summary, "This publication may not have complete alternative text descriptions.")
+}
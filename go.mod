@@ -2,4 +2,7 @@ module github.com/vidman22/epub-parser
 
 go 1.24.0
 
-require golang.org/x/net v0.49.0 // indirect
+require (
+	golang.org/x/net v0.49.0
+	golang.org/x/text v0.33.0
+)
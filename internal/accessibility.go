@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Accessibility holds the schema.org accessibility metadata of the package document
+type Accessibility struct {
+	AccessModes           []string
+	AccessModesSufficient []string // each entry is a comma separated set, e.g. "textual,visual"
+	Features              []string
+	Hazards               []string
+	Summary               string
+	ConformsTo            []string
+	CertifiedBy           string
+}
+
+// accessibility audit issue codes
+const (
+	AuditImageMissingAlt     = "img-missing-alt"
+	AuditMissingLang         = "missing-lang"
+	AuditHeadingSkip         = "heading-level-skip"
+	AuditTableWithoutHeaders = "table-without-headers"
+)
+
+type AccessibilityIssue struct {
+	Code    string
+	Href    string // content document the issue was found in
+	Message string
+}
+
+// getAccessibility reads both the EPUB 3 <meta property> and the EPUB 2 <meta name content> forms
+func getAccessibility(metas []Meta, links []Link) Accessibility {
+	a := Accessibility{}
+	for _, meta := range metas {
+		if meta.Refines != "" {
+			continue
+		}
+		property, value := meta.Property, strings.TrimSpace(meta.Text)
+		if property == "" {
+			property, value = meta.Name, strings.TrimSpace(meta.Content)
+		}
+		if value == "" {
+			continue
+		}
+		switch property {
+		case "schema:accessMode":
+			a.AccessModes = append(a.AccessModes, value)
+		case "schema:accessModeSufficient":
+			a.AccessModesSufficient = append(a.AccessModesSufficient, value)
+		case "schema:accessibilityFeature":
+			a.Features = append(a.Features, value)
+		case "schema:accessibilityHazard":
+			a.Hazards = append(a.Hazards, value)
+		case "schema:accessibilitySummary":
+			a.Summary = value
+		case "dcterms:conformsTo":
+			a.ConformsTo = append(a.ConformsTo, value)
+		case "a11y:certifiedBy":
+			a.CertifiedBy = value
+		}
+	}
+	for _, link := range links {
+		if link.Rel == "dcterms:conformsTo" && link.Href != "" && link.Refines == "" {
+			a.ConformsTo = append(a.ConformsTo, link.Href)
+		}
+	}
+	return a
+}
+
+// auditAccessibility reports images without alt, a missing language, skipped heading levels
+// and data tables without header cells in one content document
+func auditAccessibility(doc *html.Node, href string) []AccessibilityIssue {
+	var issues []AccessibilityIssue
+	report := func(code string, format string, args ...interface{}) {
+		issues = append(issues, AccessibilityIssue{Code: code, Href: href, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if getDocumentLanguage(doc) == "" {
+		report(AuditMissingLang, "document has no lang or xml:lang on html or body")
+	}
+
+	previousLevel := 0
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "img":
+				if _, ok := getAttr(n, "alt"); !ok {
+					src, _ := getAttr(n, "src")
+					report(AuditImageMissingAlt, "image %s has no alt attribute", src)
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				level := int(n.Data[1] - '0')
+				if previousLevel > 0 && level > previousLevel+1 {
+					report(AuditHeadingSkip, "heading level skips from h%d to h%d", previousLevel, level)
+				}
+				previousLevel = level
+			case "table":
+				if role, _ := getAttr(n, "role"); role != "presentation" && role != "none" && !hasDescendant(n, "th") {
+					report(AuditTableWithoutHeaders, "table has no th header cells")
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return issues
+}
+
+func getAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key && attr.Namespace == "" {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func hasDescendant(n *html.Node, tag string) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == tag {
+			return true
+		}
+		if hasDescendant(c, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessibilityIssues collects the audit issues of every chapter in reading order
+func (r *ParsedBookResult) AccessibilityIssues() []AccessibilityIssue {
+	var issues []AccessibilityIssue
+	for _, text := range r.Texts {
+		issues = append(issues, text.AccessibilityIssues...)
+	}
+	return issues
+}
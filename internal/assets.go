@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var cssURLPattern = regexp.MustCompile(`url\(\s*(['"]?)([^'")]*)(['"]?)\s*\)`)
+
+// resolveResourcePath resolves ref against the document at basePath, returning false for
+// references that don't point into the archive (data URIs, remote resources, fragments)
+func resolveResourcePath(basePath string, ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", false
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", false
+	}
+	resolved := path.Clean(path.Join(filepath.ToSlash(filepath.Dir(basePath)), u.Path))
+	if strings.HasPrefix(resolved, "..") {
+		return "", false
+	}
+	return resolved, true
+}
+
+// embedResource reads the resource ref points at from basePath and returns it as a data URI.
+// Resources missing from the manifest are not embedded, the same as images have always been
+func embedResource(r *zip.ReadCloser, basePath string, ref string, manifestHrefMap map[string]Item) (string, bool) {
+	resourcePath, ok := resolveResourcePath(basePath, ref)
+	if !ok {
+		return "", false
+	}
+	item, ok := manifestHrefMap[resourcePath]
+	if !ok {
+		return "", false
+	}
+	data, err := readZipFile(r, resourcePath)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("data:%s;base64,%s", item.MediaType, base64.StdEncoding.EncodeToString(data)), true
+}
+
+// rewriteCSSURLs embeds every url() of css, resolved against the stylesheet at basePath
+func rewriteCSSURLs(css string, basePath string, r *zip.ReadCloser, manifestHrefMap map[string]Item) string {
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		parts := cssURLPattern.FindStringSubmatch(match)
+		dataURI, ok := embedResource(r, basePath, parts[2], manifestHrefMap)
+		if !ok {
+			return match
+		}
+		return `url("` + dataURI + `")`
+	})
+}
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+const packageMediaType = "application/oebps-package+xml"
+
+// DiagnosticRenditionNotFound is reported when no rendition matches the RenditionSelector
+// and the default rendition is parsed instead
+const DiagnosticRenditionNotFound = "rendition-not-found"
+
+// RenditionSelector picks the rendition of a multiple-rendition publication. Language and Layout
+// are matched first, Index (into the package renditions) is only used when neither is set.
+// The zero value picks the first rendition, the default rendition of the container
+type RenditionSelector struct {
+	Language string // matched on the base language, "en" selects an "en-GB" rendition
+	Layout   string // reflowable or pre-paginated, renditions without rendition:layout are reflowable
+	Index    int
+}
+
+// PackageRendition describes one package document listed in META-INF/container.xml
+type PackageRendition struct {
+	Index      int
+	Path       string
+	Media      string // CSS media query the rendition targets
+	Layout     string
+	Language   string
+	AccessMode string
+	Label      string
+	Selected   bool // this rendition is the one parsed
+}
+
+// getPackageRootfiles drops the rootfiles that aren't package documents, e.g. PDF renditions
+func getPackageRootfiles(rootfiles []Rootfile) []Rootfile {
+	var packages []Rootfile
+	for _, rootfile := range rootfiles {
+		if rootfile.Type == "" || rootfile.Type == packageMediaType {
+			packages = append(packages, rootfile)
+		}
+	}
+	return packages
+}
+
+// selectRootfile returns the index of the rootfile selector picks, false when nothing matches
+func selectRootfile(rootfiles []Rootfile, selector RenditionSelector) (int, bool) {
+	if len(rootfiles) == 0 {
+		return 0, false
+	}
+	if selector.Language == "" && selector.Layout == "" {
+		if selector.Index < 0 || selector.Index >= len(rootfiles) {
+			return 0, false
+		}
+		return selector.Index, true
+	}
+	for i, rootfile := range rootfiles {
+		if selector.Layout != "" && selector.Layout != getRootfileLayout(rootfile) {
+			continue
+		}
+		if selector.Language != "" && !sameBaseLanguage(selector.Language, rootfile.Language) {
+			continue
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+func getRootfileLayout(rootfile Rootfile) string {
+	if rootfile.Layout == "" {
+		return LayoutReflowable
+	}
+	return strings.TrimSpace(rootfile.Layout)
+}
+
+func sameBaseLanguage(a string, b string) bool {
+	tagA, okA := normalizeLanguage(a)
+	tagB, okB := normalizeLanguage(b)
+	if !okA || !okB {
+		return false
+	}
+	baseA, _ := tagA.Base()
+	baseB, _ := tagB.Base()
+	return baseA == baseB
+}
+
+// chooseRootfile sets the rootfile of the container the book is parsed from
+func chooseRootfile(container *Container, selector RenditionSelector) ([]PackageRendition, []Diagnostic, error) {
+	rootfiles := getPackageRootfiles(container.Rootfiles)
+	if len(rootfiles) == 0 {
+		return nil, nil, fmt.Errorf("container has no package document")
+	}
+
+	var diagnostics []Diagnostic
+	selected, ok := selectRootfile(rootfiles, selector)
+	if !ok {
+		diagnostics = append(diagnostics, Diagnostic{
+			Code:    DiagnosticRenditionNotFound,
+			Message: fmt.Sprintf("no rendition matches %+v, using the default rendition", selector),
+		})
+	}
+	container.Rootfile = rootfiles[selected]
+
+	renditions := make([]PackageRendition, len(rootfiles))
+	for i, rootfile := range rootfiles {
+		renditions[i] = PackageRendition{
+			Index:      i,
+			Path:       rootfile.Path,
+			Media:      rootfile.Media,
+			Layout:     rootfile.Layout,
+			Language:   rootfile.Language,
+			AccessMode: rootfile.AccessMode,
+			Label:      rootfile.Label,
+			Selected:   i == selected,
+		}
+	}
+	return renditions, diagnostics, nil
+}
@@ -0,0 +1,484 @@
+package parser
+
+import (
+	"archive/zip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func Test_language_spans(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html xml:lang="en"><body>
+<p>The word <span lang="es">hola  amigo</span> means hello friend.</p>
+<p xml:lang="fr">Bonjour.</p></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang := getDocumentLanguage(doc)
+	text, spans := extractPlainText(doc, lang)
+
+	if lang != "en" {
+		t.Logf("document language expected en but is %q", lang)
+		t.Fail()
+	}
+	if text != "The word hola amigo means hello friend.\nBonjour." {
+		t.Logf("unexpected text %q", text)
+		t.Fail()
+	}
+	expected := []LanguageSpan{
+		{Start: 0, End: 8, Lang: "en"},
+		{Start: 9, End: 19, Lang: "es"},
+		{Start: 20, End: 39, Lang: "en"},
+		{Start: 40, End: 48, Lang: "fr"},
+	}
+	if len(spans) != len(expected) {
+		t.Fatalf("spans expected %v but is %v", expected, spans)
+	}
+	for i, span := range spans {
+		if span != expected[i] {
+			t.Logf("span[%d] expected %v but is %v (%q)", i, expected[i], span, text[span.Start:span.End])
+			t.Fail()
+		}
+	}
+}
+
+func Test_accessibility_audit(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+<h1>Title</h1><h3>Skipped</h3>
+<img src="a.png"/><img src="b.png" alt=""/>
+<table><tr><td>1</td></tr></table>
+<table role="presentation"><tr><td>layout</td></tr></table>
+<table><tr><th>head</th></tr></table>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make(map[string]int)
+	for _, issue := range auditAccessibility(doc, "OEBPS/c1.xhtml") {
+		codes[issue.Code]++
+	}
+	expected := map[string]int{
+		AuditMissingLang:         1,
+		AuditHeadingSkip:         1,
+		AuditImageMissingAlt:     1,
+		AuditTableWithoutHeaders: 1,
+	}
+	for code, count := range expected {
+		if codes[code] != count {
+			t.Logf("%s expected %d issues but is %d", code, count, codes[code])
+			t.Fail()
+		}
+	}
+}
+
+func Test_rendition(t *testing.T) {
+	book := &Book{ZipReader: &zip.ReadCloser{}}
+	rendition := getRendition(book, []Meta{
+		{Property: "rendition:layout", Text: "pre-paginated"},
+		{Property: "rendition:spread", Text: "landscape"},
+		{Property: "rendition:orientation", Text: "portrait"},
+		{Name: "original-resolution", Content: "1200x1600"},
+	})
+	if !rendition.IsFixedLayout() || rendition.Spread != "landscape" || rendition.Orientation != "portrait" {
+		t.Logf("unexpected rendition %+v", rendition)
+		t.Fail()
+	}
+	if rendition.Viewport == nil || *rendition.Viewport != (Viewport{Width: 1200, Height: 1600}) {
+		t.Logf("original-resolution expected 1200x1600 but is %v", rendition.Viewport)
+		t.Fail()
+	}
+
+	layout, spread := getItemLayout(Itemref{Properties: "rendition:layout-reflowable page-spread-left"}, rendition)
+	if layout != LayoutReflowable || spread != PageSpreadLeft {
+		t.Logf("itemref overrides expected reflowable left but are %s %s", layout, spread)
+		t.Fail()
+	}
+	if layout, _ := getItemLayout(Itemref{}, Rendition{}); layout != LayoutReflowable {
+		t.Logf("default layout expected reflowable but is %s", layout)
+		t.Fail()
+	}
+
+	pages := map[string]Viewport{
+		`<html><head><meta name="viewport" content="width=600, height=800"/></head><body><p>page</p></body></html>`:             {Width: 600, Height: 800},
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 1024 768"><image width="1024" height="768" href="p1.jpg"/></svg>`: {Width: 1024, Height: 768},
+		`<html><body><svg width="300" height="400"></svg></body></html>`:                                                        {Width: 300, Height: 400},
+	}
+	for page, expected := range pages {
+		doc, err := html.Parse(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		viewport := getDocumentViewport(doc)
+		if viewport == nil || *viewport != expected {
+			t.Logf("viewport of %s expected %v but is %v", page, expected, viewport)
+			t.Fail()
+		}
+	}
+}
+
+func Test_page_image_ref(t *testing.T) {
+	pages := map[string]string{
+		`<html><body><div class="page"><img src="../images/p1.jpg" width="800" height="1200"/></div></body></html>`:                                            "../images/p1.jpg",
+		`<html><body><svg viewBox="0 0 800 1200"><title>p2</title><image xlink:href="p2.jpg" xmlns:xlink="http://www.w3.org/1999/xlink"/></svg></body></html>`: "p2.jpg",
+		`<html><body><p><img src="p3.jpg"/></p><p>Chapter one</p></body></html>`:                                                                               "",
+		`<html><body><img src="p4.jpg"/><img src="p5.jpg"/></body></html>`:                                                                                     "",
+	}
+	for page, expected := range pages {
+		doc, err := html.Parse(strings.NewReader(page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, size, ok := getPageImageRef(doc)
+		if ref != expected || ok != (expected != "") {
+			t.Logf("image of %s expected %q but is %q", page, expected, ref)
+			t.Fail()
+		}
+		if ok && (size == nil || *size != Viewport{Width: 800, Height: 1200}) {
+			t.Logf("page size of %s expected 800x1200 but is %v", page, size)
+			t.Fail()
+		}
+	}
+
+	manifestHrefMap := map[string]Item{"OEBPS/images/p1.jpg": {Id: "p1", MediaType: "image/jpeg"}}
+	image, ok := getPageImage("page1", "OEBPS/text/page1.xhtml", "../images/p1.jpg", manifestHrefMap)
+	if !ok || image.Href != "OEBPS/images/p1.jpg" || image.MediaType != "image/jpeg" || image.FileName != "p1.jpg" {
+		t.Logf("unexpected page image %+v", image)
+		t.Fail()
+	}
+	if _, ok := getPageImage("page2", "OEBPS/text/page2.xhtml", "p2.jpg", manifestHrefMap); ok {
+		t.Log("image missing from the manifest shouldn't be a page")
+		t.Fail()
+	}
+}
+
+func Test_parse_xhtml(t *testing.T) {
+	source := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en">
+<head><title>t</title><style><![CDATA[ p > span { color: red } ]]></style></head>
+<body><p><a id="p12"/>First &amp; <span epub:type="pagebreak"/>second&nbsp;part</p><div/><p>After<?page 13?> the div</p>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10"><image xlink:href="a.png"/></svg>
+</body></html>`
+	doc, err := parseContentDocument([]byte(source), "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := html.Render(&b, doc); err != nil {
+		t.Fatal(err)
+	}
+	output := b.String()
+	for _, expected := range []string{
+		"<p><a id=\"p12\"></a>First &amp; <span epub:type=\"pagebreak\"></span>second\u00a0part</p><div></div><p>After the div</p>",
+		`<style> p > span { color: red } </style>`,
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en">`,
+		`<image xlink:href="a.png"></image>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+	if lang := getDocumentLanguage(doc); lang != "en" {
+		t.Logf("document language expected en but is %q", lang)
+		t.Fail()
+	}
+
+	svg, err := parseContentDocument([]byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 600 800"><rect width="1" height="1"/></svg>`), "image/svg+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viewport := getDocumentViewport(svg); viewport == nil || *viewport != (Viewport{Width: 600, Height: 800}) {
+		t.Logf("svg page viewport expected 600x800 but is %v", viewport)
+		t.Fail()
+	}
+
+	// not well-formed, parsed as HTML instead
+	broken, err := parseContentDocument([]byte(`<html><body><p>unclosed<br></body></html>`), "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, _ := extractPlainText(broken, "")
+	if text != "unclosed" {
+		t.Logf("fallback text expected unclosed but is %q", text)
+		t.Fail()
+	}
+}
+
+func Test_content_encoding(t *testing.T) {
+	encode := func(enc encoding.Encoding, s string) []byte {
+		data, err := enc.NewEncoder().Bytes([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	documents := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"windows-1252 declaration", encode(charmap.Windows1252, `<?xml version="1.0" encoding="windows-1252"?><html xmlns="http://www.w3.org/1999/xhtml"><body><p>café – “quoted”</p></body></html>`), "café – “quoted”"},
+		{"ISO-8859-1 declaration", encode(charmap.ISO8859_1, `<?xml version="1.0" encoding="ISO-8859-1"?><html xmlns="http://www.w3.org/1999/xhtml"><body><p>señor</p></body></html>`), "señor"},
+		{"Shift_JIS meta", encode(japanese.ShiftJIS, `<html><head><meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS"/></head><body><p>吾輩は猫である</p></body></html>`), "吾輩は猫である"},
+		{"GB2312 meta charset", encode(simplifiedchinese.GBK, `<html><head><meta charset="gb2312"/></head><body><p>红楼梦</p></body></html>`), "红楼梦"},
+		{"UTF-16 byte order mark", encode(unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), `<?xml version="1.0" encoding="UTF-16"?><html xmlns="http://www.w3.org/1999/xhtml"><body><p>naïve</p></body></html>`), "naïve"},
+		{"undeclared latin-1", encode(charmap.Windows1252, `<html><body><p>déjà vu</p></body></html>`), "déjà vu"},
+		{"utf-8", []byte(`<?xml version="1.0" encoding="utf-8"?><html xmlns="http://www.w3.org/1999/xhtml"><body><p>déjà vu</p></body></html>`), "déjà vu"},
+	}
+	for _, document := range documents {
+		doc, err := parseContentDocument(document.data, "application/xhtml+xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text, _ := extractPlainText(doc, ""); text != document.expected {
+			t.Logf("%s expected %q but is %q", document.name, document.expected, text)
+			t.Fail()
+		}
+	}
+
+	ncx := encode(charmap.ISO8859_1, `<?xml version="1.0" encoding="ISO-8859-1"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/"><navMap><navPoint id="n1">
+<navLabel><text>Élan vital</text></navLabel><content src="c1.xhtml"/></navPoint></navMap></ncx>`)
+	tocMap, err := ParseNcx(ncx, "OEBPS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := tocMap["OEBPS/c1.xhtml"]; title != "Élan vital" {
+		t.Logf("ncx title expected Élan vital but is %q in %v", title, tocMap)
+		t.Fail()
+	}
+}
+
+func Test_nav_doc(t *testing.T) {
+	// html.Parse would read everything after <title/> as the title
+	nav := []byte(`<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title/><link rel="stylesheet" href="nav.css"/></head>
+<body><nav epub:type="toc"><ol>
+<li><a href="c1.xhtml"><span class="num"/>Chapter One</a></li>
+<li><a href="c2.xhtml#start">Chapter Two</a><ol/></li>
+</ol></nav></body></html>`)
+	tocMap, err := ParseNavDoc(nav, "OEBPS", "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"OEBPS/c1.xhtml": "Chapter One", "OEBPS/c2.xhtml": "Chapter Two"}
+	if len(tocMap) != len(expected) {
+		t.Logf("expected %v but got %v", expected, tocMap)
+		t.Fail()
+	}
+	for href, title := range expected {
+		if tocMap[href] != title {
+			t.Logf("%s expected %q but is %q", href, title, tocMap[href])
+			t.Fail()
+		}
+	}
+}
+
+func Test_mathml(t *testing.T) {
+	doc, err := parseContentDocument([]byte(`<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:m="http://www.w3.org/1998/Math/MathML"><body>
+<p>Area <m:math alttext="pi r squared"><m:mi>π</m:mi><m:msup><m:mi>r</m:mi><m:mn>2</m:mn></m:msup></m:math> of a circle.</p>
+<p><math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mrow><mi>x</mi><mo>+</mo><mn>1</mn></mrow>
+<annotation encoding="application/x-tex">x+1</annotation><annotation-xml encoding="text/html"><script>alert(1)</script></annotation-xml></semantics></math></p>
+<p><math><mfrac><mi>a</mi><mi>b</mi></mfrac></math></p>
+</body></html>`), "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	extractRawHTML(doc, &b, nil, "OEBPS/c1.xhtml", map[string]Item{}, DefaultSanitizePolicy)
+	output := b.String()
+	for _, expected := range []string{
+		`<math alttext="pi r squared"><mi>π</mi><msup><mi>r</mi><mn>2</mn></msup></math>`,
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mrow><mi>x</mi><mo>+</mo><mn>1</mn></mrow>`,
+		`<annotation encoding="application/x-tex">x+1</annotation></semantics></math>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+	if strings.Contains(output, "alert") {
+		t.Logf("expected annotation-xml to be dropped from %s", output)
+		t.Fail()
+	}
+
+	text, _ := extractPlainText(doc, "")
+	for _, expected := range []string{"Area pi r squared of a circle.", "x+1", "a b"} {
+		if !strings.Contains(text, expected) {
+			t.Logf("expected %q in the text %q", expected, text)
+			t.Fail()
+		}
+	}
+
+	replaceMathWithLaTeX(doc)
+	b.Reset()
+	extractRawHTML(doc, &b, nil, "OEBPS/c1.xhtml", map[string]Item{}, DefaultSanitizePolicy)
+	output = b.String()
+	for _, expected := range []string{
+		`<span class="math">\(\pi r^{2}\)</span>`,
+		`<span class="math">\[x+1\]</span>`,
+		`<span class="math">\(\frac{a}{b}\)</span>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+}
+
+func Test_mathml_to_latex(t *testing.T) {
+	cases := map[string]string{
+		`<mi>sin</mi><mi>θ</mi>`: `\sin\theta`,
+		`<mi>α</mi><mi>x</mi>`:   `\alpha x`,
+		`<munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover><msub><mi>x</mi><mi>i</mi></msub>`: `\sum_{i=1}^{n}x_{i}`,
+		`<msqrt><mi>b</mi><mo>−</mo><mn>4</mn><mi>a</mi><mi>c</mi></msqrt>`:                                                         `\sqrt{b-4ac}`,
+		`<mroot><mi>x</mi><mn>3</mn></mroot>`:     `\sqrt[3]{x}`,
+		`<mover><mi>v</mi><mo>→</mo></mover>`:     `\vec{v}`,
+		`<mfenced><mi>a</mi><mi>b</mi></mfenced>`: `\left(a,b\right)`,
+		`<mi mathvariant="double-struck">R</mi>`:  `\mathbb{R}`,
+		`<mtext>if x_1</mtext>`:                   `\text{if x\_1}`,
+		`<mtable><mtr><mtd><mn>1</mn></mtd><mtd><mn>0</mn></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mn>1</mn></mtd></mtr></mtable>`: `\begin{matrix}1 & 0 \\ 0 & 1\end{matrix}`,
+	}
+	for markup, expected := range cases {
+		doc, err := html.Parse(strings.NewReader(`<math>` + markup + `</math>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var math *html.Node
+		var find func(*html.Node)
+		find = func(n *html.Node) {
+			if n.Type == html.ElementNode && n.Data == "math" {
+				math = n
+			}
+			for c := n.FirstChild; c != nil && math == nil; c = c.NextSibling {
+				find(c)
+			}
+		}
+		find(doc)
+		if latex := mathToLaTeX(math); latex != expected {
+			t.Logf("%s expected %s but got %s", markup, expected, latex)
+			t.Fail()
+		}
+	}
+}
+
+func Test_chapter_media(t *testing.T) {
+	r, err := zip.OpenReader("../fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// any archive file does as the media, only its path and manifest entry matter
+	manifestHrefMap := map[string]Item{
+		"OEBPS/7337271621053197105_cover.jpg": {Id: "clip", Href: "7337271621053197105_cover.jpg", MediaType: "audio/mpeg"},
+	}
+	durations := getMediaDurations([]Meta{
+		{Refines: "#clip", Property: "media:duration", Text: "0:01:30.5"},
+		{Property: "media:duration", Text: "1:00:00"},
+	})
+	chapter := `<html><body><p>Listen:</p>
+<audio controls="" autoplay=""><source src="7337271621053197105_cover.jpg" type="audio/mp3"/><source src="missing.ogg"/>Your reading system can't play audio</audio>
+<video src="https://example.com/film.mp4" poster="7337271621053197105_cover.jpg"><track src="missing.vtt"/></video>
+</body></html>`
+	doc, err := html.Parse(strings.NewReader(chapter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	media := getChapterMedia(doc, "OEBPS/chapter.xhtml", manifestHrefMap, durations)
+	expected := []MediaResource{
+		{Element: "audio", Href: "OEBPS/7337271621053197105_cover.jpg", MediaType: "audio/mpeg", Duration: 90500 * time.Millisecond},
+		{Element: "video", Href: "https://example.com/film.mp4", Poster: "OEBPS/7337271621053197105_cover.jpg"},
+	}
+	if len(media) != len(expected) {
+		t.Fatalf("expected %d media but got %+v", len(expected), media)
+	}
+	for i := range expected {
+		if media[i] != expected[i] {
+			t.Logf("media %d expected %+v but is %+v", i, expected[i], media[i])
+			t.Fail()
+		}
+	}
+
+	text, _ := extractPlainText(doc, "")
+	if strings.Contains(text, "can't play") {
+		t.Logf("expected the fallback content left out of the text %q", text)
+		t.Fail()
+	}
+
+	var b strings.Builder
+	extractRawHTML(doc, &b, r, "OEBPS/chapter.xhtml", manifestHrefMap, DefaultSanitizePolicy)
+	output := b.String()
+	for _, expected := range []string{
+		`<audio controls=""><source src="data:audio/mpeg;base64,`,
+		`<video src="https://example.com/film.mp4" poster="data:audio/mpeg;base64,`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %.300s", expected, output)
+			t.Fail()
+		}
+	}
+	for _, unexpected := range []string{"autoplay", "missing.ogg", "missing.vtt", "<track"} {
+		if strings.Contains(output, unexpected) {
+			t.Logf("expected %q to be removed", unexpected)
+			t.Fail()
+		}
+	}
+
+	// media over the size cap keep the path Content.Media lists them under
+	defer func(size uint64) { maxEmbeddedMediaSize = size }(maxEmbeddedMediaSize)
+	maxEmbeddedMediaSize = 1024
+	if doc, err = html.Parse(strings.NewReader(chapter)); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	extractRawHTML(doc, &b, r, "OEBPS/chapter.xhtml", manifestHrefMap, DefaultSanitizePolicy)
+	output = b.String()
+	for _, expected := range []string{
+		`<audio controls=""><source src="OEBPS/7337271621053197105_cover.jpg" type="audio/mp3">`,
+		`poster="OEBPS/7337271621053197105_cover.jpg"`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %.300s", expected, output)
+			t.Fail()
+		}
+	}
+	if strings.Contains(output, "base64") {
+		t.Logf("expected no media over the cap embedded in %.300s", output)
+		t.Fail()
+	}
+}
+
+func Test_parse_clock_value(t *testing.T) {
+	cases := map[string]time.Duration{
+		"0:01:30.5": 90500 * time.Millisecond,
+		"02:30":     150 * time.Second,
+		"90s":       90 * time.Second,
+		"1.5min":    90 * time.Second,
+		"250ms":     250 * time.Millisecond,
+		"2h":        2 * time.Hour,
+		"12.25":     12250 * time.Millisecond,
+	}
+	for value, expected := range cases {
+		if duration, ok := parseClockValue(value); !ok || duration != expected {
+			t.Logf("%s expected %v but is %v", value, expected, duration)
+			t.Fail()
+		}
+	}
+	for _, value := range []string{"", "abc", "1:2:3:4", "-5s"} {
+		if _, ok := parseClockValue(value); ok {
+			t.Logf("%q expected to be invalid", value)
+			t.Fail()
+		}
+	}
+}
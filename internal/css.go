@@ -0,0 +1,470 @@
+package parser
+
+import (
+	"archive/zip"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// stylesheet is the subset of CSS the parser acts on: style rules with type, class, id,
+// descendant and child selectors. Rules for other media than the screen are left out
+type stylesheet struct {
+	Rules     []cssRule
+	FontFaces [][]cssDeclaration
+}
+
+type cssRule struct {
+	Selectors    []cssSelector
+	Declarations []cssDeclaration
+	Media        string // query of the @media block the rule is in, empty outside one
+}
+
+type cssDeclaration struct {
+	Property  string
+	Value     string
+	Important bool
+}
+
+// cssSelector is a complex selector, compounds run left to right and combinators[i]
+// joins compounds[i] and compounds[i+1]
+type cssSelector struct {
+	Text        string
+	compounds   []cssCompound
+	combinators []string
+	specificity int
+}
+
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+// cssProperties holds the cascaded value of each property set on an element
+type cssProperties map[string]string
+
+var (
+	cssCommentPattern  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssCompoundPattern = regexp.MustCompile(`^(\*|[a-zA-Z][a-zA-Z0-9-]*)?((?:[.#][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+	cssSubclassPattern = regexp.MustCompile(`[.#][^.#]+`)
+)
+
+func parseStylesheet(css string) *stylesheet {
+	s := &stylesheet{}
+	parseCSSRules(cssCommentPattern.ReplaceAllString(css, ""), "", s)
+	return s
+}
+
+func parseCSSRules(css string, media string, s *stylesheet) {
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return
+		}
+		open := strings.IndexByte(css, '{')
+		if semicolon := strings.IndexByte(css, ';'); semicolon >= 0 && (open < 0 || semicolon < open) {
+			// @import, @charset, @namespace and stray semicolons
+			css = css[semicolon+1:]
+			continue
+		}
+		if open < 0 {
+			return
+		}
+		end := matchingBrace(css, open)
+		prelude := strings.TrimSpace(css[:open])
+		block := css[open+1 : end]
+		if end < len(css) {
+			css = css[end+1:]
+		} else {
+			css = ""
+		}
+
+		lowerPrelude := strings.ToLower(prelude)
+		switch {
+		case strings.HasPrefix(lowerPrelude, "@media"):
+			query := strings.TrimSpace(prelude[len("@media"):])
+			if mediaApplies(query) {
+				parseCSSRules(block, query, s)
+			}
+		case strings.HasPrefix(lowerPrelude, "@font-face"):
+			s.FontFaces = append(s.FontFaces, parseDeclarations(block))
+		case strings.HasPrefix(lowerPrelude, "@"):
+			// @page, @supports, @keyframes and the like
+		default:
+			var selectors []cssSelector
+			for _, text := range strings.Split(prelude, ",") {
+				if selector, ok := parseSelector(text); ok {
+					selectors = append(selectors, selector)
+				}
+			}
+			if len(selectors) > 0 {
+				s.Rules = append(s.Rules, cssRule{Selectors: selectors, Declarations: parseDeclarations(block), Media: media})
+			}
+		}
+	}
+}
+
+// matchingBrace returns the index of the brace closing the block opened at open, or the end of
+// css for an unterminated block
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// mediaApplies reports whether a media query list can match a screen reading system
+func mediaApplies(query string) bool {
+	if query == "" {
+		return true
+	}
+	for _, part := range strings.Split(strings.ToLower(query), ",") {
+		fields := strings.Fields(part)
+		negated := len(fields) > 0 && fields[0] == "not"
+		if len(fields) > 0 && (fields[0] == "only" || negated) {
+			fields = fields[1:]
+		}
+		mediaType := "all"
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "(") {
+			mediaType = fields[0]
+		}
+		screen := mediaType == "all" || mediaType == "screen" || mediaType == "amzn-kf8"
+		if screen != negated {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDeclarations parses a declaration block, expanding the shorthands the parser reads
+func parseDeclarations(block string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, part := range strings.Split(block, ";") {
+		property, value, ok := strings.Cut(part, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		important := false
+		if i := strings.Index(strings.ToLower(value), "!important"); i >= 0 {
+			value, important = strings.TrimSpace(value[:i]), true
+		}
+		if property == "" || value == "" {
+			continue
+		}
+		for _, declaration := range expandShorthand(property, value) {
+			declaration.Important = important
+			declarations = append(declarations, declaration)
+		}
+	}
+	return declarations
+}
+
+func expandShorthand(property string, value string) []cssDeclaration {
+	switch property {
+	case "margin", "padding":
+		sides := strings.Fields(value)
+		if len(sides) == 0 || len(sides) > 4 {
+			break
+		}
+		// top, right, bottom and left following the 1 to 4 value rules
+		for len(sides) < 4 {
+			switch len(sides) {
+			case 1:
+				sides = append(sides, sides[0])
+			case 2:
+				sides = append(sides, sides[0])
+			case 3:
+				sides = append(sides, sides[1])
+			}
+		}
+		return []cssDeclaration{
+			{Property: property, Value: value},
+			{Property: property + "-top", Value: sides[0]},
+			{Property: property + "-right", Value: sides[1]},
+			{Property: property + "-bottom", Value: sides[2]},
+			{Property: property + "-left", Value: sides[3]},
+		}
+	case "font":
+		// the shorthand resets what it doesn't mention
+		style, weight, variant := "normal", "normal", "normal"
+		for _, field := range strings.Fields(strings.ToLower(value)) {
+			switch field {
+			case "italic", "oblique":
+				style = field
+			case "bold", "bolder", "lighter", "100", "200", "300", "400", "500", "600", "700", "800", "900":
+				weight = field
+			case "small-caps":
+				variant = field
+			}
+		}
+		return []cssDeclaration{
+			{Property: property, Value: value},
+			{Property: "font-style", Value: style},
+			{Property: "font-weight", Value: weight},
+			{Property: "font-variant", Value: variant},
+		}
+	case "text-decoration":
+		return []cssDeclaration{
+			{Property: property, Value: value},
+			{Property: "text-decoration-line", Value: value},
+		}
+	}
+	return []cssDeclaration{{Property: property, Value: value}}
+}
+
+// parseSelector parses type, universal, class and id selectors joined by descendant and child
+// combinators. Anything else can't be matched reliably without a full engine and is rejected
+func parseSelector(text string) (cssSelector, bool) {
+	selector := cssSelector{Text: strings.TrimSpace(text)}
+	fields := strings.Fields(strings.ReplaceAll(selector.Text, ">", " > "))
+	combinator := ""
+	for _, field := range fields {
+		if field == ">" {
+			if len(selector.compounds) == 0 || combinator != "" {
+				return cssSelector{}, false
+			}
+			combinator = ">"
+			continue
+		}
+		m := cssCompoundPattern.FindStringSubmatch(field)
+		if m == nil {
+			return cssSelector{}, false
+		}
+		compound := cssCompound{}
+		if m[1] != "" && m[1] != "*" {
+			compound.tag = strings.ToLower(m[1])
+			selector.specificity++
+		}
+		for _, part := range cssSubclassPattern.FindAllString(m[2], -1) {
+			if part[0] == '#' {
+				compound.id = part[1:]
+				selector.specificity += 10000
+			} else {
+				compound.classes = append(compound.classes, part[1:])
+				selector.specificity += 100
+			}
+		}
+		if len(selector.compounds) > 0 {
+			if combinator == "" {
+				combinator = " "
+			}
+			selector.combinators = append(selector.combinators, combinator)
+		}
+		selector.compounds = append(selector.compounds, compound)
+		combinator = ""
+	}
+	if len(selector.compounds) == 0 || combinator != "" {
+		return cssSelector{}, false
+	}
+	return selector, true
+}
+
+func (c cssCompound) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && !strings.EqualFold(c.tag, n.Data) {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := getAttr(n, "id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := getAttr(n, "class")
+		classes := strings.Fields(class)
+		for _, want := range c.classes {
+			found := false
+			for _, have := range classes {
+				if have == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s cssSelector) matches(n *html.Node) bool {
+	return s.matchFrom(len(s.compounds)-1, n)
+}
+
+func (s cssSelector) matchFrom(i int, n *html.Node) bool {
+	if !s.compounds[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if s.combinators[i-1] == ">" {
+		return n.Parent != nil && s.matchFrom(i-1, n.Parent)
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if s.matchFrom(i-1, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// cascadeWeight orders competing declarations: important first, then inline styles,
+// then specificity and finally source order
+type cascadeWeight struct {
+	important   bool
+	inline      bool
+	specificity int
+	order       int
+}
+
+func (w cascadeWeight) beats(other cascadeWeight) bool {
+	switch {
+	case w.important != other.important:
+		return w.important
+	case w.inline != other.inline:
+		return w.inline
+	case w.specificity != other.specificity:
+		return w.specificity > other.specificity
+	}
+	return w.order > other.order
+}
+
+// getDeclaredStyles cascades the stylesheets and style attributes over doc, returning the
+// properties set on each element. Inheritance is left to the callers
+func getDeclaredStyles(doc *html.Node, sheets []*stylesheet) map[*html.Node]cssProperties {
+	styles := make(map[*html.Node]cssProperties)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			properties := cssProperties{}
+			weights := make(map[string]cascadeWeight)
+			apply := func(declarations []cssDeclaration, weight cascadeWeight) {
+				for _, declaration := range declarations {
+					weight.important = declaration.Important
+					if current, ok := weights[declaration.Property]; ok && !weight.beats(current) {
+						continue
+					}
+					weights[declaration.Property] = weight
+					properties[declaration.Property] = declaration.Value
+				}
+			}
+			order := 0
+			for _, sheet := range sheets {
+				for _, rule := range sheet.Rules {
+					order++
+					specificity := -1
+					for _, selector := range rule.Selectors {
+						if selector.specificity > specificity && selector.matches(n) {
+							specificity = selector.specificity
+						}
+					}
+					if specificity >= 0 {
+						apply(rule.Declarations, cascadeWeight{specificity: specificity, order: order})
+					}
+				}
+			}
+			if style, ok := getAttr(n, "style"); ok {
+				apply(parseDeclarations(style), cascadeWeight{inline: true, order: order + 1})
+			}
+			if len(properties) > 0 {
+				styles[n] = properties
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return styles
+}
+
+// getDocumentStylesheets collects the linked and embedded stylesheets of a content document in
+// document order, linked sheets are parsed once per book through cache
+func getDocumentStylesheets(doc *html.Node, r *zip.ReadCloser, contentFilePath string, cache map[string]*stylesheet) []*stylesheet {
+	var sheets []*stylesheet
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "link":
+				if cssPath, ok := getStylesheetPath(n, contentFilePath); ok {
+					sheet, cached := cache[cssPath]
+					if !cached {
+						if css, err := readZipFile(r, cssPath); err == nil {
+							sheet = parseStylesheet(string(toUTF8(css)))
+						}
+						cache[cssPath] = sheet
+					}
+					if sheet != nil {
+						sheets = append(sheets, sheet)
+					}
+				}
+			case "style":
+				if media, _ := getAttr(n, "media"); mediaApplies(media) {
+					if css := mergeStyleText(n); css != "" {
+						sheets = append(sheets, parseStylesheet(css))
+					}
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return sheets
+}
+
+// getStylesheetPath resolves the stylesheet a link element points at, skipping alternate sheets
+// and sheets for other media
+func getStylesheetPath(link *html.Node, contentFilePath string) (string, bool) {
+	rel, _ := getAttr(link, "rel")
+	rels := strings.Fields(strings.ToLower(rel))
+	stylesheetRel, alternate := false, false
+	for _, r := range rels {
+		stylesheetRel = stylesheetRel || r == "stylesheet"
+		alternate = alternate || r == "alternate"
+	}
+	if !stylesheetRel || alternate {
+		return "", false
+	}
+	if media, _ := getAttr(link, "media"); !mediaApplies(media) {
+		return "", false
+	}
+	href, _ := getAttr(link, "href")
+	return resolveResourcePath(contentFilePath, href)
+}
+
+// mergeStyleText joins the text of a style element into its only child and returns it. XHTML
+// comments and CDATA sections split the text into several nodes, CSS is only ever read as a whole
+func mergeStyleText(style *html.Node) string {
+	var css strings.Builder
+	for c := style.FirstChild; c != nil; c = style.FirstChild {
+		if c.Type == html.TextNode {
+			css.WriteString(c.Data)
+		}
+		style.RemoveChild(c)
+	}
+	if css.Len() > 0 {
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: css.String()})
+	}
+	return css.String()
+}
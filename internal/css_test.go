@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func Test_css_cascade(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body><div id="main" class="chapter">
+<p class="note first">a</p><p class="note" style="color: green">b</p><blockquote><p>c</p></blockquote></div></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := parseStylesheet(`
+@charset "utf-8";
+/* comment { color: red } */
+p { color: black; margin: 0 2em }
+.note { color: blue }
+#main .note { color: purple }
+p.note { color: navy !important }
+div > p { font-style: italic }
+blockquote p, td { font-weight: bold }
+p:first-child { color: red }
+@media print { p { color: gray } }
+@media screen and (min-width: 600px) { .first { text-align: center } }
+@font-face { font-family: "Book"; src: url(fonts/book.otf) }`)
+
+	if len(sheet.FontFaces) != 1 {
+		t.Logf("expected one @font-face but got %d", len(sheet.FontFaces))
+		t.Fail()
+	}
+	styles := getDeclaredStyles(doc, []*stylesheet{sheet})
+	var paragraphs []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "p" {
+			paragraphs = append(paragraphs, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	expected := []cssProperties{
+		{"color": "navy", "font-style": "italic", "text-align": "center", "margin-left": "2em"},
+		{"color": "navy", "font-style": "italic", "margin-top": "0"},
+		{"color": "black", "font-weight": "bold"},
+	}
+	for i, p := range paragraphs {
+		for property, value := range expected[i] {
+			if styles[p][property] != value {
+				t.Logf("paragraph %d %s expected %s but is %q", i, property, value, styles[p][property])
+				t.Fail()
+			}
+		}
+	}
+	if _, ok := styles[paragraphs[2]]["font-style"]; ok {
+		t.Log("the child combinator shouldn't match a p inside blockquote")
+		t.Fail()
+	}
+}
+
+func Test_semantic_formatting(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><style>
+.italic { font-style: italic }
+.b { font-weight: 700 }
+.center { text-align: center }
+.sc { font-variant: small-caps }
+.quote { margin-left: 2em; margin-right: 2em }
+div.chapter { margin-left: 5%; margin-right: 5% }
+.strike { text-decoration: line-through }
+.fn { vertical-align: super; font-size: 0.7em }
+</style></head><body>
+<p class="center">A <span class="italic">very</span> <span class="b">bold</span> claim<span class="fn">1</span>.</p>
+<div class="quote italic"><p>Quoted text</p>trailing</div>
+<p><em class="italic">already</em> <span class="sc">Small</span> <span class="strike">gone</span></p>
+<div class="chapter"><h1>One</h1><p>Text here.</p></div>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheets := getDocumentStylesheets(doc, nil, "OEBPS/c1.xhtml", map[string]*stylesheet{})
+	applySemanticFormatting(doc, getDeclaredStyles(doc, sheets))
+	var b strings.Builder
+	extractRawHTML(doc, &b, nil, "OEBPS/c1.xhtml", map[string]Item{}, DefaultSanitizePolicy)
+	output := b.String()
+
+	for _, expected := range []string{
+		`<p align="center">A <span><em>very</em></span> <span><strong>bold</strong></span> claim<span><sup>1</sup></span>.</p>`,
+		`<div><p><em>Quoted text</em></p><em>trailing</em></div>`,
+		`<p><em>already</em> <span class="small-caps">Small</span> <span><s>gone</s></span></p>`,
+		`<div><h1>One</h1><p>Text here.</p></div>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+	// side margins don't make a quote, container divs have them as often
+	if strings.Contains(output, "<blockquote") {
+		t.Logf("expected the margined divs to stay divs in %s", output)
+		t.Fail()
+	}
+}
+
+func Test_hidden_content(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><style>
+.pagenum { display: none }
+@media print { .print { display: none } }
+.ghost { visibility: hidden }
+.shown { visibility: visible }
+aside { display: none }
+</style></head><body>
+<p>One<span class="pagenum">[12]</span> two</p>
+<p class="print">printed</p>
+<p>A<img src="a.png" alt="alt"/><span style="display:none">fallback</span>B</p>
+<div class="ghost">gone <span class="shown">kept</span></div>
+<p hidden="">hidden attribute</p>
+<aside epub:type="footnote">a note</aside>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheets := getDocumentStylesheets(doc, nil, "OEBPS/c1.xhtml", map[string]*stylesheet{})
+	removeHiddenContent(doc, getDeclaredStyles(doc, sheets))
+	var b strings.Builder
+	extractRawHTML(doc, &b, nil, "OEBPS/c1.xhtml", map[string]Item{}, DefaultSanitizePolicy)
+	output := b.String()
+
+	for _, hidden := range []string{"[12]", "fallback", "gone", "hidden attribute"} {
+		if strings.Contains(output, hidden) {
+			t.Logf("expected %q to be removed from %s", hidden, output)
+			t.Fail()
+		}
+	}
+	for _, visible := range []string{"One two", "printed", "kept", "a note"} {
+		if !strings.Contains(output, visible) {
+			t.Logf("expected %q in %s", visible, output)
+			t.Fail()
+		}
+	}
+}
+
+func Test_scoped_stylesheet(t *testing.T) {
+	styles := newBookStyles("epub-test", DefaultSanitizePolicy)
+	css := styles.scopeCSS(`@import url(remote.css);
+html, body { margin: 0 }
+body.poetry > p.verse, blockquote:not(.a, .b) { text-indent: -2em; margin-left: 2em }
+p::first-letter { float: left; font-size: 3em }
+.banner { position: fixed; top: 0; color: red }
+.x { background: url(javascript:alert(1)); width: expression(alert(1)) }
+.bg { background-image: url("data:image/png;base64,AAAA") }
+@media print { .page { display: none } }
+@media screen and (min-width: 40em) { td { padding: 1em } }
+@page { margin: 0 }
+@font-face { font-family: "Book"; src: url(missing.otf) }
+.close { content: "</style><script>alert(1)</script>" }`, "OEBPS/styles.css", nil, map[string]Item{})
+	styles.css.WriteString(css)
+	output := styles.String()
+
+	for _, expected := range []string{
+		".epub-test { margin: 0 }\n",
+		".epub-test p.verse, .epub-test blockquote:not(.a, .b) { text-indent: -2em; margin-left: 2em }",
+		".epub-test p::first-letter { float: left; font-size: 3em }",
+		".epub-test .banner { top: 0; color: red }",
+		`.epub-test .bg { background-image: url("data:image/png;base64,AAAA") }`,
+		"@media screen and (min-width: 40em) {\n.epub-test td { padding: 1em }\n}",
+		`@font-face { font-family: "Book"; src: url(missing.otf) }`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %q in %s", expected, output)
+			t.Fail()
+		}
+	}
+	for _, unexpected := range []string{"@import", "javascript", "expression", ".page", "@page", "</style"} {
+		if strings.Contains(output, unexpected) {
+			t.Logf("expected %q to be left out of %s", unexpected, output)
+			t.Fail()
+		}
+	}
+}
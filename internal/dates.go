@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"strings"
+	"time"
+)
+
+// precision of a W3CDTF date
+const (
+	DatePrecisionYear  = "year"
+	DatePrecisionMonth = "month"
+	DatePrecisionDay   = "day"
+	DatePrecisionTime  = "time"
+)
+
+// EPUB 2 opf:event values, EPUB 3 only has publication (dc:date) and modification (dcterms:modified)
+const (
+	DateEventPublication  = "publication"
+	DateEventCreation     = "creation"
+	DateEventModification = "modification"
+)
+
+type BookDate struct {
+	Raw       string
+	Time      time.Time // zero when Raw isn't a W3CDTF date
+	Precision string    // empty when Raw couldn't be parsed
+	Event     string
+}
+
+var w3cdtfLayouts = []struct {
+	layout    string
+	precision string
+}{
+	{"2006", DatePrecisionYear},
+	{"2006-01", DatePrecisionMonth},
+	{"2006-01-02", DatePrecisionDay},
+	{"2006-01-02T15:04Z07:00", DatePrecisionTime},
+	{"2006-01-02T15:04:05Z07:00", DatePrecisionTime},
+	{"2006-01-02T15:04:05.999999999Z07:00", DatePrecisionTime},
+	// not W3CDTF but common enough in the wild
+	{"2006-01-02T15:04:05", DatePrecisionTime},
+	{"2006-01-02 15:04:05", DatePrecisionTime},
+}
+
+// parseW3CDTF parses a date and reports its precision, the precision is empty when nothing matched
+func parseW3CDTF(raw string, event string) BookDate {
+	date := BookDate{Raw: strings.TrimSpace(raw), Event: strings.ToLower(strings.TrimSpace(event))}
+	for _, l := range w3cdtfLayouts {
+		t, err := time.Parse(l.layout, date.Raw)
+		if err != nil {
+			continue
+		}
+		// calibre writes 0101-01-01 when the date is unknown
+		if t.Year() <= 101 {
+			return date
+		}
+		date.Time = t
+		date.Precision = l.precision
+		return date
+	}
+	return date
+}
+
+func getBookDates(dates *[]Date) []BookDate {
+	if dates == nil {
+		return nil
+	}
+	var result []BookDate
+	for _, d := range *dates {
+		if strings.TrimSpace(d.Text) == "" {
+			continue
+		}
+		result = append(result, parseW3CDTF(d.Text, d.Event))
+	}
+	return result
+}
+
+// getEventDates picks the publication, creation and modification dates.
+// A dc:date without an event is a publication date, dcterms:modified wins over an opf:event modification
+func getEventDates(dates []BookDate, modified string) (publication *BookDate, creation *BookDate, modification *BookDate) {
+	for i := range dates {
+		d := &dates[i]
+		switch d.Event {
+		case DateEventPublication:
+			if publication == nil || publication.Event != DateEventPublication {
+				publication = d
+			}
+		case "", "original-publication":
+			if publication == nil {
+				publication = d
+			}
+		case DateEventCreation:
+			if creation == nil {
+				creation = d
+			}
+		case DateEventModification:
+			if modification == nil {
+				modification = d
+			}
+		}
+	}
+	if modified != "" {
+		m := parseW3CDTF(modified, DateEventModification)
+		modification = &m
+	}
+	return publication, creation, modification
+}
+
+func getPublicationYear(publication *BookDate) *int {
+	if publication == nil || publication.Precision == "" {
+		return nil
+	}
+	year := publication.Time.Year()
+	return &year
+}
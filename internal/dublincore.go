@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetadataValue is a single Dublin Core element with its EPUB 3 refinements applied
+type MetadataValue struct {
+	Value            string
+	Id               string
+	Lang             string
+	Dir              string
+	FileAs           string
+	DisplaySeq       int
+	AlternateScripts []AlternateScript
+}
+
+type AlternateScript struct {
+	Value string
+	Lang  string
+	Dir   string
+}
+
+// DublinCore holds every value of every DC element in document order, or display-seq order when refined
+type DublinCore struct {
+	Identifier  []MetadataValue
+	Title       []MetadataValue
+	Language    []MetadataValue
+	Creator     []MetadataValue
+	Contributor []MetadataValue
+	Publisher   []MetadataValue
+	Subject     []MetadataValue
+	Description []MetadataValue
+	Date        []MetadataValue
+	Type        []MetadataValue
+	Format      []MetadataValue
+	Source      []MetadataValue
+	Relation    []MetadataValue
+	Coverage    []MetadataValue
+	Rights      []MetadataValue
+}
+
+type dcMetadata struct {
+	Identifier  []dcElement `xml:"identifier"`
+	Title       []dcElement `xml:"title"`
+	Language    []dcElement `xml:"language"`
+	Creator     []dcElement `xml:"creator"`
+	Contributor []dcElement `xml:"contributor"`
+	Publisher   []dcElement `xml:"publisher"`
+	Subject     []dcElement `xml:"subject"`
+	Description []dcElement `xml:"description"`
+	Date        []dcElement `xml:"date"`
+	Type        []dcElement `xml:"type"`
+	Format      []dcElement `xml:"format"`
+	Source      []dcElement `xml:"source"`
+	Relation    []dcElement `xml:"relation"`
+	Coverage    []dcElement `xml:"coverage"`
+	Rights      []dcElement `xml:"rights"`
+	Meta        []Meta      `xml:"meta"`
+	Link        []Link      `xml:"link"`
+}
+
+// dcElement covers the attributes used by both EPUB 2 (opf:*) and EPUB 3 dc elements
+type dcElement struct {
+	Text   string `xml:",chardata"`
+	Id     string `xml:"id,attr,omitempty"`
+	Lang   string `xml:"lang,attr,omitempty"`
+	Dir    string `xml:"dir,attr,omitempty"`
+	FileAs string `xml:"file-as,attr,omitempty"`
+	Role   string `xml:"role,attr,omitempty"`
+	Scheme string `xml:"scheme,attr,omitempty"`
+	Event  string `xml:"event,attr,omitempty"`
+	// opf:authority is not part of EPUB 2 but some tools write it on dc:subject
+	Authority string `xml:"authority,attr,omitempty"`
+}
+
+// ParseDublinCore reads all dc elements and the dcterms:modified property of the package document
+func ParseDublinCore(opf *OPFPackage, book *Book) error {
+	var md dcMetadata
+	if opf.Metadata != nil {
+		md = opf.Metadata.dc
+	}
+	metaMap := *getMetaMap(md.Meta)
+
+	values := func(elements []dcElement) []MetadataValue {
+		return getMetadataValues(elements, md.Meta, metaMap, opf.Lang, opf.Dir)
+	}
+
+	book.DublinCore = DublinCore{
+		Identifier:  values(md.Identifier),
+		Title:       values(md.Title),
+		Language:    values(md.Language),
+		Creator:     values(md.Creator),
+		Contributor: values(md.Contributor),
+		Publisher:   values(md.Publisher),
+		Subject:     values(md.Subject),
+		Description: values(md.Description),
+		Date:        values(md.Date),
+		Type:        values(md.Type),
+		Format:      values(md.Format),
+		Source:      values(md.Source),
+		Relation:    values(md.Relation),
+		Coverage:    values(md.Coverage),
+		Rights:      values(md.Rights),
+	}
+	book.Modified = getModified(md.Meta)
+	book.dcMetadata = md
+	return nil
+}
+
+func getMetadataValues(elements []dcElement, metas []Meta, metaMap map[string]map[string]Meta, defaultLang string, defaultDir string) []MetadataValue {
+	if len(elements) == 0 {
+		return nil
+	}
+	values := make([]MetadataValue, len(elements))
+	for i, element := range elements {
+		value := MetadataValue{
+			Value:  strings.TrimSpace(element.Text),
+			Id:     element.Id,
+			Lang:   element.Lang,
+			Dir:    element.Dir,
+			FileAs: element.FileAs,
+		}
+		if value.Lang == "" {
+			value.Lang = defaultLang
+		}
+		if value.Dir == "" {
+			value.Dir = defaultDir
+		}
+		if fileAs := getMetadata(metaMap, element.Id, "file-as"); fileAs != "" {
+			value.FileAs = strings.TrimSpace(fileAs)
+		}
+		if seq, err := strconv.Atoi(strings.TrimSpace(getMetadata(metaMap, element.Id, "display-seq"))); err == nil {
+			value.DisplaySeq = seq
+		}
+		for _, meta := range getMetaRefinements(metas, element.Id, "alternate-script") {
+			value.AlternateScripts = append(value.AlternateScripts, AlternateScript{
+				Value: strings.TrimSpace(meta.Text),
+				Lang:  meta.Lang,
+				Dir:   meta.Dir,
+			})
+		}
+		values[i] = value
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return lessDisplaySeq(values[i].DisplaySeq, values[j].DisplaySeq)
+	})
+	return values
+}
+
+// lessDisplaySeq orders sequenced values first, unsequenced (0) values keep their place after them
+func lessDisplaySeq(a int, b int) bool {
+	if a == 0 || b == 0 {
+		return a != 0 && b == 0
+	}
+	return a < b
+}
+
+// getMetaRefinements returns every meta refining id with property; the meta map only keeps the last one
+func getMetaRefinements(metas []Meta, id string, property string) []Meta {
+	if id == "" {
+		return nil
+	}
+	var refinements []Meta
+	for _, meta := range metas {
+		if meta.Property == property && strings.TrimPrefix(meta.Refines, "#") == id {
+			refinements = append(refinements, meta)
+		}
+	}
+	return refinements
+}
+
+func getModified(metas []Meta) string {
+	for _, meta := range metas {
+		if meta.Refines != "" {
+			continue
+		}
+		if meta.Property == "dcterms:modified" {
+			return strings.TrimSpace(meta.Text)
+		}
+		if meta.Name == "dcterms:modified" && meta.Content != "" {
+			return strings.TrimSpace(meta.Content)
+		}
+	}
+	return ""
+}
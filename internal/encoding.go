@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// encoding declarations are looked for in the start of the document only, like browsers do
+const encodingPrescanBytes = 1024
+
+var (
+	xmlEncodingPattern = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding\s*=\s*["']([^"']+)["']`)
+	metaCharsetPattern = regexp.MustCompile(`(?i)<meta[^>]+?charset\s*=\s*["']?\s*([a-zA-Z0-9_:.-]+)`)
+	byteOrderMarks     = [][]byte{{0xEF, 0xBB, 0xBF}, {0xFE, 0xFF}, {0xFF, 0xFE}}
+	utf16Labels        = map[string]bool{"utf-16": true, "utf-16le": true, "utf-16be": true}
+)
+
+// decodeByteOrderMark transcodes documents starting with a UTF-8 or UTF-16 byte order mark
+// to UTF-8 without one
+func decodeByteOrderMark(data []byte) ([]byte, bool) {
+	for _, bom := range byteOrderMarks {
+		if bytes.HasPrefix(data, bom) {
+			decoded, _, err := transform.Bytes(unicode.BOMOverride(unicode.UTF8.NewDecoder()), data)
+			return decoded, err == nil
+		}
+	}
+	return data, false
+}
+
+// detectEncoding reads the encoding of a document without a byte order mark from its XML
+// declaration or meta charset, nil means UTF-8. Undeclared text that isn't valid UTF-8 is taken
+// as windows-1252, the encoding most mislabelled older books are in
+func detectEncoding(data []byte) encoding.Encoding {
+	head := data
+	if len(head) > encodingPrescanBytes {
+		head = head[:encodingPrescanBytes]
+	}
+	for _, pattern := range []*regexp.Regexp{xmlEncodingPattern, metaCharsetPattern} {
+		m := pattern.FindSubmatch(head)
+		if m == nil {
+			continue
+		}
+		label := strings.ToLower(strings.TrimSpace(string(m[1])))
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			continue
+		}
+		// a declaration readable as ASCII can't be in UTF-16, whatever it says
+		if utf16Labels[label] || enc == unicode.UTF8 {
+			return nil
+		}
+		return enc
+	}
+	if !utf8.Valid(data) {
+		return charmap.Windows1252
+	}
+	return nil
+}
+
+// toUTF8 transcodes a content document to UTF-8, UTF-8 documents are returned as they are
+func toUTF8(data []byte) []byte {
+	if decoded, ok := decodeByteOrderMark(data); ok {
+		return decoded
+	}
+	enc := detectEncoding(data)
+	if enc == nil {
+		return data
+	}
+	decoded, _, err := transform.Bytes(enc.NewDecoder(), data)
+	if err != nil {
+		return data
+	}
+	return decoded
+}
+
+// xmlCharsetReader lets encoding/xml read package documents and NCX files in any encoding the
+// WHATWG encoding standard knows. UTF-16 documents are transcoded from their byte order mark
+// before decoding starts, so their declaration no longer describes the bytes
+func xmlCharsetReader(label string, input io.Reader) (io.Reader, error) {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if utf16Labels[label] {
+		return input, nil
+	}
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// newXMLDecoder is xml.NewDecoder for documents that may not be UTF-8
+func newXMLDecoder(data []byte) *xml.Decoder {
+	data, _ = decodeByteOrderMark(data)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = xmlCharsetReader
+	return decoder
+}
@@ -6,16 +6,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-)
 
-type OPFHeaderDetails struct {
-	Version          string `xml:"version,attr"`
-	UniqueIdentifier string `xml:"unique-identifier,attr"`
-	ID               string `xml:"id,attr,omitempty"`
-	Prefix           string `xml:"prefix,attr,omitempty"`
-	Lang             string `xml:"lang,attr,omitempty"`
-	Dir              string `xml:"dir,attr,omitempty"`
-}
+	"golang.org/x/text/language"
+)
 
 func getLikelyTOC(manifestItems *[]Item, navDir string) (likelyTocPathV2 string, likelyTocPathV3 string) {
 
@@ -38,19 +31,42 @@ func getLikelyTOC(manifestItems *[]Item, navDir string) (likelyTocPathV2 string,
 	return likelyTocPathV2, likelyTocPathV3
 }
 
+// getItemMediaType returns the media type of the manifest item at the full path itemPath
+func getItemMediaType(manifestItems *[]Item, rootDir string, itemPath string) string {
+	if manifestItems == nil {
+		return ""
+	}
+	for _, item := range *manifestItems {
+		if filepath.Join(rootDir, item.Href) == itemPath {
+			return item.MediaType
+		}
+	}
+	return ""
+}
+
 // OpenBook will open epub2 and epub3 files toc.ncx is epub2 toc.xhtml is epub3
 func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
+	return OpenBookWithOptions(reader, Options{})
+}
+
+// OpenBookWithOptions is OpenBook with the behaviour adjusted by opts
+func OpenBookWithOptions(reader *zip.ReadCloser, opts Options) (*ParsedBookResult, error) {
 	book := &Book{ZipReader: reader}
 	err := book.ReadXML("META-INF/container.xml", &book.Container)
 	if err != nil {
 		return nil, err
 	}
-	header := OPFHeaderDetails{}
-	err = book.ReadXML(book.Container.Rootfile.Path, &header)
+	renditions, renditionDiagnostics, err := chooseRootfile(&book.Container, opts.Rendition)
+	if err != nil {
+		return nil, err
+	}
+	// the package document is decoded once, the version specific parsers and the Dublin Core read it
+	opf := OPFPackage{}
+	err = book.ReadXML(book.Container.Rootfile.Path, &opf)
 	if err != nil {
 		return nil, err
 	}
-	ebookVersion, err := strconv.ParseFloat(header.Version, 64)
+	ebookVersion, err := strconv.ParseFloat(opf.Version, 64)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +81,7 @@ func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
 
 	switch {
 	case ebookVersion >= 3.0 && ebookVersion < 4.0:
-		err := ParseOpf3(book.Container.Rootfile.Path, book)
+		err := ParseOpf3(&opf, book)
 		if err != nil {
 			return nil, err
 		}
@@ -74,13 +90,13 @@ func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read zip file %s: %w", likelyTocPathV3, err)
 		}
-		tocMap, err = ParseNavDoc(fBytes, rootDir)
+		tocMap, err = ParseNavDoc(fBytes, rootDir, getItemMediaType(book.Manifest.Item, rootDir, likelyTocPathV3))
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse nav doc: %w", err)
 		}
 
 	case ebookVersion >= 2.0 && ebookVersion < 3.0:
-		err := ParseOpf(book.Container.Rootfile.Path, book)
+		err := ParseOpf(&opf, book)
 		if err != nil {
 			return nil, err
 		}
@@ -99,12 +115,36 @@ func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
 		return nil, fmt.Errorf("%f is not a supported version", ebookVersion)
 	}
 
-	res, cover, err := processEpubContent(Params{
-		rootDir:       rootDir,
-		manifestItems: *book.Manifest.Item,
-		spineItemRefs: book.Spine.Itemrefs,
-		tocMap:        tocMap,
-		r:             reader,
+	err = ParseDublinCore(&opf, book)
+	if err != nil {
+		return nil, err
+	}
+
+	rendition := getRendition(book, book.dcMetadata.Meta)
+
+	var styles *bookStyles
+	if opts.PreserveStyles {
+		identifier := ""
+		if book.Metadata.MainId != nil {
+			identifier = book.Metadata.MainId.Id
+		}
+		styles = newBookStyles(getStyleScope(identifier, book.Container.Rootfile.Path), opts.sanitizer())
+	}
+
+	res, pages, cover, err := processEpubContent(Params{
+		rootDir:          rootDir,
+		manifestItems:    *book.Manifest.Item,
+		spineItemRefs:    book.Spine.Itemrefs,
+		tocMap:           tocMap,
+		coverId:          book.Metadata.CoverId,
+		rendition:        rendition,
+		fixedLayoutPages: opts.FixedLayoutPages,
+		imagePages:       opts.ImagePages,
+		sanitizer:        opts.sanitizer(),
+		styles:           styles,
+		mathLaTeX:        opts.MathLaTeX,
+		mediaDurations:   getMediaDurations(book.dcMetadata.Meta),
+		r:                reader,
 	})
 
 	if err != nil {
@@ -112,32 +152,72 @@ func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
 	}
 
 	md := book.Metadata
+	languageTags := getLanguageTags(md.Language)
+	// titles inherit the package xml:lang, then the book language
+	bookLanguage := opf.Lang
+	if bookLanguage == "" && len(languageTags) > 0 {
+		bookLanguage = languageTags[0].String()
+	}
+	mainTitle, subtitle, sortTitle := getTitleParts(md.Titles, bookLanguage)
+	identifiers := getBookIdentifiers(md.Identifier, md.MainId)
+	dates := getBookDates(md.Date)
+	publication, creation, modification := getEventDates(dates, book.Modified)
+	subjects := getSubjects(book.dcMetadata.Subject, *getMetaMap(book.dcMetadata.Meta))
+	genre, _ := opts.genreMapper().MapGenre(subjects)
+	primaryLanguage, detection, diagnostics := getBookLanguage(res, languageTags, opts)
+	languageID := 0
+	if primaryLanguage != language.Und {
+		languageID, _ = opts.languageResolver().ResolveLanguageID(primaryLanguage)
+		applyBookLanguage(res, primaryLanguage.String())
+	}
 
 	resMetadata := ResultMetadata{
 		MainId: func() string {
+			if md.MainId != nil {
+				return md.MainId.Id
+			}
 			if md.Identifier != nil && len(*md.Identifier) > 0 {
 				return (*md.Identifier)[0].Id
 			}
 			return ""
 		}(),
 		Title: func() string {
+			if mainTitle.Title != "" {
+				return mainTitle.Title
+			}
 			if md.Title != nil && len(*md.Title) > 0 {
 				return (*md.Title)[0].Text
 			}
 			return ""
 		}(),
+		Subtitle:  subtitle,
+		SortTitle: sortTitle,
+		Titles: func() []Title {
+			if md.Titles != nil {
+				return *md.Titles
+			}
+			return nil
+		}(),
 		Identifier: func() string {
 			if md.Identifier != nil && len(*md.Identifier) > 0 {
 				return (*md.Identifier)[0].Id
 			}
 			return ""
 		}(),
+		Identifiers: identifiers,
+		Isbn:        getIsbn(identifiers),
 		Language: func() string {
+			if primaryLanguage != language.Und {
+				return primaryLanguage.String()
+			}
 			if md.Language != nil && len(*md.Language) > 0 {
 				return (*md.Language)[0].Text
 			}
 			return ""
 		}(),
+		Languages:         languageStrings(languageTags),
+		LanguageID:        languageID,
+		LanguageDetection: detection,
 		Creator: func() string {
 			if md.Creator != nil && len(*md.Creator) > 0 {
 				return (*md.Creator)[0].Name
@@ -163,6 +243,9 @@ func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
 			}
 			return ""
 		}(),
+		Subjects: subjects,
+		Genre:    genre.Name,
+		GenreID:  genre.ID,
 		Description: func() string {
 			if md.Description != nil && len(*md.Description) > 0 {
 				return (*md.Description)[0].Text
@@ -170,15 +253,43 @@ func OpenBook(reader *zip.ReadCloser) (*ParsedBookResult, error) {
 			return ""
 		}(),
 		Date: func() string {
+			if publication != nil {
+				return publication.Raw
+			}
 			if md.Date != nil && len(*md.Date) > 0 {
 				return (*md.Date)[0].Text
 			}
 			return ""
 		}(),
+		Dates:            dates,
+		PublicationDate:  publication,
+		CreationDate:     creation,
+		ModificationDate: modification,
+		PublicationYear:  getPublicationYear(publication),
+		People:           getPeople(md.Creator, md.Contributor),
+		Series:           getSeries(book.dcMetadata.Meta),
+		Accessibility:    getAccessibility(book.dcMetadata.Meta, book.dcMetadata.Link),
+		Rendition:        rendition,
+		PageProgression:  book.Spine.PageProgressionDirection,
+		ImageOnly:        pages != nil,
+		DublinCore:       book.DublinCore,
+		Modified:         book.Modified,
 	}
 
-	return &ParsedBookResult{
-		Metadata: &resMetadata,
-		Texts:    res,
-	}, nil
+	result := &ParsedBookResult{
+		Metadata:    &resMetadata,
+		Manifest:    getManifestItems(*book.Manifest.Item, rootDir),
+		Texts:       res,
+		Renditions:  renditions,
+		Diagnostics: append(renditionDiagnostics, diagnostics...),
+	}
+	if styles != nil {
+		result.Stylesheet = styles.String()
+		result.StyleScope = styles.scope
+	}
+	if opts.ImagePages && pages != nil {
+		result.Texts = nil
+		result.Pages = pages
+	}
+	return result, nil
 }
@@ -2,29 +2,36 @@ package parser
 
 import (
 	"archive/zip"
-	"bytes"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"math"
-	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 )
 
 type Params struct {
-	rootDir       string
-	manifestItems []Item
-	spineItemRefs []Itemref
-	tocMap        map[string]string
-	title         string
-	r             *zip.ReadCloser
+	rootDir          string
+	manifestItems    []Item
+	spineItemRefs    []Itemref
+	tocMap           map[string]string
+	coverId          string
+	title            string
+	rendition        Rendition
+	fixedLayoutPages bool
+	imagePages       bool
+	sanitizer        *SanitizePolicy
+	styles           *bookStyles // collects the book's stylesheets in PreserveStyles mode
+	mathLaTeX        bool
+	mediaDurations   map[string]time.Duration // media:duration by manifest id
+	r                *zip.ReadCloser
 }
 
 // contentMap is map[fullContentPath]Title
-func processEpubContent(params Params) ([]Content, Cover, error) {
+// pages is only non-nil when every spine item is a single image, their data is read in image pages mode
+func processEpubContent(params Params) ([]Content, []PageImage, Cover, error) {
 	manifestItems := params.manifestItems
 	rootDir := params.rootDir
 	spineItemRefs := params.spineItemRefs
@@ -32,53 +39,166 @@ func processEpubContent(params Params) ([]Content, Cover, error) {
 	r := params.r
 
 	//var cover *Cover
-	manifestIDMap := make(map[string]string)
+	manifestIDItemMap := make(map[string]Item)
 	manifestHrefMap := make(map[string]Item)
 	likelyCoverHref := ""
+	coverImageHref := ""
 	for _, item := range manifestItems {
 		fullHref := filepath.Join(rootDir, item.Href)
-		manifestIDMap[item.Id] = fullHref
+		manifestIDItemMap[item.Id] = item
 		manifestHrefMap[fullHref] = item
-		if strings.Contains(item.Id, "cover") {
+		switch {
+		case item.hasProperty(PropertyCoverImage) || (params.coverId != "" && item.Id == params.coverId):
+			if coverImageHref == "" {
+				coverImageHref = fullHref
+			}
+		case strings.Contains(item.Id, "cover") && strings.HasPrefix(item.MediaType, "image/"):
 			likelyCoverHref = fullHref
 		}
 	}
+	if coverImageHref != "" {
+		likelyCoverHref = coverImageHref
+	}
 
 	var texts []Content
+	var pages []PageImage
+	stylesheetCache := make(map[string]*stylesheet)
+	imageOnly := true
 
 	for _, itemRef := range spineItemRefs {
-		contentFilePath, ok := manifestIDMap[itemRef.Idref]
+		spineItem, ok := manifestIDItemMap[itemRef.Idref]
+		if !ok {
+			continue
+		}
+		layout, pageSpread := getItemLayout(itemRef, params.rendition)
+		spinePage := false
+		if strings.HasPrefix(spineItem.MediaType, "image/") {
+			// an image straight in the spine is a page of its own, its fallback document only renders it
+			fullHref := filepath.Join(rootDir, spineItem.Href)
+			if page, ok := getPageImage(spineItem.Id, fullHref, filepath.Base(fullHref), manifestHrefMap); ok {
+				page.PageSpread = pageSpread
+				pages = append(pages, page)
+				spinePage = true
+			}
+		}
+		// images and foreign resources in the spine are rendered through their fallback chain
+		item, ok := resolveFallback(spineItem, manifestIDItemMap)
 		if !ok {
 			continue
 		}
+		contentFilePath := filepath.Join(rootDir, item.Href)
 
 		// the toc map isn't guaranteed to have the titles for all the spine items unfortunately
 		Title := tocMap[contentFilePath]
 
-		if strings.Contains(itemRef.Idref, "cover") {
-			continue
-		}
-		var combinedHTML strings.Builder
+		fixedLayoutPage := params.fixedLayoutPages && layout == LayoutPrePaginated
 
 		fileData, err := readZipFile(r, contentFilePath)
 		if err != nil {
 			continue
 		}
 
-		doc, err := html.Parse(bytes.NewReader(fileData))
+		doc, err := parseContentDocument(fileData, item.MediaType)
 		if err != nil {
 			continue
 		}
 
-		possibleTitle := extractRawHTML(doc, &combinedHTML, r, contentFilePath, manifestHrefMap)
+		// text hidden by the book's CSS, like alt fallbacks and print-only page numbers, never reaches the
+		// formatted output. Fixed layout pages and PreserveStyles chapters keep the publisher's markup as
+		// written, with their stylesheets deciding what shows
+		sheets := getDocumentStylesheets(doc, r, contentFilePath, stylesheetCache)
+		styles := getDeclaredStyles(doc, sheets)
+		semanticFormatting := !fixedLayoutPage && params.styles == nil
+		if semanticFormatting {
+			removeHiddenContent(doc, styles)
+		}
+
+		if imageOnly && !spinePage {
+			page, ok := PageImage{}, false
+			if ref, size, isImagePage := getPageImageRef(doc); isImagePage {
+				page, ok = getPageImage(item.Id, contentFilePath, ref, manifestHrefMap)
+				page.PageSpread = pageSpread
+				page.Viewport = getDocumentViewport(doc)
+				if page.Viewport == nil {
+					page.Viewport = size
+				}
+			}
+			if ok {
+				pages = append(pages, page)
+			} else {
+				imageOnly = false
+			}
+		}
+
+		switch {
+		case semanticFormatting:
+			applySemanticFormatting(doc, styles)
+		case params.styles != nil && !fixedLayoutPage:
+			// the publisher's stylesheets come with the chapters, so do their classes
+			params.styles.addDocument(doc, r, contentFilePath, manifestHrefMap)
+		}
+
+		// a fixed layout cover is a page of the book like any other
+		if strings.Contains(itemRef.Idref, "cover") && !fixedLayoutPage {
+			continue
+		}
+		language := getDocumentLanguage(doc)
+		text, languageSpans := extractPlainText(doc, language)
+		accessibilityIssues := auditAccessibility(doc, contentFilePath)
+		media := getChapterMedia(doc, contentFilePath, manifestHrefMap, params.mediaDurations)
+
+		if params.mathLaTeX && !fixedLayoutPage {
+			// after the plain text, which reads the alttext of the MathML
+			replaceMathWithLaTeX(doc)
+		}
+		var combinedHTML strings.Builder
+
+		possibleTitle := extractRawHTML(doc, &combinedHTML, r, contentFilePath, manifestHrefMap, params.sanitizer)
 		combinedHTML.WriteString("\n<hr />\n")
 		stringHtml := combinedHTML.String()
 		if Title == "" {
 			Title = possibleTitle[0:int(math.Min(float64(len(possibleTitle)), 50))]
 		}
 
-		texts = append(texts, Content{Html: stringHtml, Title: Title})
+		var viewport *Viewport
+		if layout == LayoutPrePaginated {
+			viewport = getDocumentViewport(doc)
+			if viewport == nil {
+				viewport = params.rendition.Viewport
+			}
+		}
+		if fixedLayoutPage {
+			// rendering the page mutates doc, so it goes after everything else reading it
+			stringHtml, err = renderFixedLayoutPage(doc, r, contentFilePath, manifestHrefMap, viewport, params.sanitizer)
+			if err != nil {
+				continue
+			}
+		}
+
+		texts = append(texts, Content{
+			Id:                  item.Id,
+			Href:                contentFilePath,
+			Html:                stringHtml,
+			Text:                text,
+			Title:               Title,
+			Language:            language,
+			LanguageSpans:       languageSpans,
+			AccessibilityIssues: accessibilityIssues,
+			Layout:              layout,
+			PageSpread:          pageSpread,
+			Viewport:            viewport,
+			Media:               media,
+		})
 	}
+	if !imageOnly || len(pages) == 0 {
+		pages = nil
+	}
+	if params.imagePages {
+		for i := range pages {
+			pages[i].File, _ = readZipFile(r, pages[i].Href)
+		}
+	}
+
 	var cover Cover
 	if likelyCoverHref != "" {
 		coverData, err := readZipFile(r, likelyCoverHref)
@@ -93,7 +213,7 @@ func processEpubContent(params Params) ([]Content, Cover, error) {
 			}
 		}
 	}
-	return texts, cover, nil
+	return texts, pages, cover, nil
 }
 
 func readZipFile(r *zip.ReadCloser, filePath string) ([]byte, error) {
@@ -115,7 +235,7 @@ func readZipFile(r *zip.ReadCloser, filePath string) ([]byte, error) {
 	return nil, fmt.Errorf("file %s not found in archive", cleanPath)
 }
 
-func extractRawHTML(n *html.Node, w io.StringWriter, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item) string {
+func extractRawHTML(n *html.Node, w io.StringWriter, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item, policy *SanitizePolicy) string {
 	var findBodyAndExtract func(*html.Node)
 	foundBody := false
 	isFirstChild := false
@@ -125,7 +245,7 @@ func extractRawHTML(n *html.Node, w io.StringWriter, r *zip.ReadCloser, contentF
 			foundBody = true
 			for c := node.FirstChild; c != nil; c = c.NextSibling {
 				isFirstChild = true
-				titleString := renderNodeRaw(isFirstChild, c, w, r, contentFilePath, manifestHrefMap)
+				titleString := renderNodeRaw(isFirstChild, c, w, r, contentFilePath, manifestHrefMap, policy)
 				if titleString != "" {
 					firstText = titleString
 				}
@@ -149,10 +269,10 @@ func extractRawHTML(n *html.Node, w io.StringWriter, r *zip.ReadCloser, contentF
 	return firstText
 }
 
-func renderNodeRaw(isFirstChild bool, n *html.Node, w io.StringWriter, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item) string {
+func renderNodeRaw(isFirstChild bool, n *html.Node, w io.StringWriter, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item, policy *SanitizePolicy) string {
 	switch n.Type {
 	case html.TextNode:
-		w.WriteString(n.Data)
+		writeText(w, n)
 		if isFirstChild {
 			return n.Data
 		}
@@ -160,7 +280,31 @@ func renderNodeRaw(isFirstChild bool, n *html.Node, w io.StringWriter, r *zip.Re
 		tag := n.Data
 		switch tag {
 
-		case "script", "style", "link", "meta", "head", "title", "svg":
+		case "script", "style", "link", "meta", "head":
+			return ""
+		case "title":
+			// the document title, a title inside an svg describes the graphic
+			if n.Namespace == "" {
+				return ""
+			}
+		case "svg":
+			if image, ok := getSVGWrappedImage(n); ok {
+				// a wrapper scaling a single image, like most covers and title pages, is just that image
+				return renderNodeRaw(isFirstChild, svgImageToImg(n, image), w, r, contentFilePath, manifestHrefMap, policy)
+			}
+		}
+
+		switch policy.elementAction(tag) {
+		case elementDrop:
+			return ""
+		case elementUnwrap:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					writeUnwrappedText(w, c)
+					continue
+				}
+				renderNodeRaw(isFirstChild, c, w, r, contentFilePath, manifestHrefMap, policy)
+			}
 			return ""
 		}
 
@@ -177,53 +321,45 @@ func renderNodeRaw(isFirstChild bool, n *html.Node, w io.StringWriter, r *zip.Re
 
 			if src != "" {
 				// Resolve the image path relative to the current content file
-				imagePath, err := url.JoinPath(filepath.Dir(contentFilePath), src)
-				if err != nil {
-					return ""
-				}
-
-				imageData, err := readZipFile(r, imagePath)
-				if err != nil {
-					return ""
-				}
-
-				item, ok := manifestHrefMap[imagePath]
+				dataURI, ok := embedResource(r, contentFilePath, src, manifestHrefMap)
 				if !ok {
 					return ""
 				}
-				mediaType := item.MediaType
-
-				encodedData := base64.StdEncoding.EncodeToString(imageData)
-				dataURI := fmt.Sprintf("data:%s;base64,%s", mediaType, encodedData)
 
 				// Add the new src attribute with the data URI
 				n.Attr = append(n.Attr, html.Attribute{Key: "src", Val: dataURI})
 			}
 		}
 
-		var openTag strings.Builder
-		openTag.WriteString("<")
-		openTag.WriteString(tag)
+		if mediaSourceElements[tag] && n.Namespace == "" {
+			// audio and video fall back on their sources, a source or track without a file is nothing
+			if !embedMediaSources(n, r, contentFilePath, manifestHrefMap) && (tag == "source" || tag == "track") {
+				return ""
+			}
+		}
 
-		for _, attr := range n.Attr {
-			if attr.Key == "class" {
-				continue
+		if tag == "image" && n.Namespace == "svg" {
+			// the same as img, images are embedded or left out
+			dataURI, ok := embedResource(r, contentFilePath, getSVGImageHref(n), manifestHrefMap)
+			if !ok {
+				return ""
 			}
-			openTag.WriteString(" ")
-			openTag.WriteString(attr.Key)
-			openTag.WriteString(`="`)
-			openTag.WriteString(html.EscapeString(attr.Val))
-			openTag.WriteString(`"`)
+			var attrs []html.Attribute
+			for _, attr := range n.Attr {
+				if attr.Key != "href" {
+					attrs = append(attrs, attr)
+				}
+			}
+			n.Attr = append(attrs, html.Attribute{Key: "href", Val: dataURI})
 		}
-		openTag.WriteString(">")
-		w.WriteString(openTag.String())
+
+		// classes were replaced by the formatting pass unless the book's styles are preserved
+		writeStartTag(w, n, policy.sanitizeAttributes(n))
 
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			renderNodeRaw(isFirstChild, c, w, r, contentFilePath, manifestHrefMap)
-		}
-		if n.FirstChild != nil || tag != "img" { // Self-closing for img if no children
-			w.WriteString("</" + tag + ">")
+			renderNodeRaw(isFirstChild, c, w, r, contentFilePath, manifestHrefMap, policy)
 		}
+		writeEndTag(w, n)
 
 	case html.CommentNode:
 		return ""
@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// utility classes the formatting pass writes for styles HTML has no element for, they replace
+// the book's classes in the chapter HTML unless PreserveStyles is set
+const (
+	ClassSmallCaps = "small-caps"
+	ClassUnderline = "underline"
+)
+
+// elements that already carry the formatting the pass would add
+var (
+	italicElements = setOf("em", "i", "cite", "var", "dfn")
+	boldElements   = setOf("strong", "b", "th", "h1", "h2", "h3", "h4", "h5", "h6")
+	alignElements  = setOf("p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "td", "th", "caption", "blockquote")
+)
+
+// inheritedFormatting tracks the inherited text styles and whether an ancestor already
+// expressed them as markup
+type inheritedFormatting struct {
+	italic, italicMarked bool
+	bold, boldMarked     bool
+}
+
+// applySemanticFormatting turns the formatting the book's CSS gives elements into markup that
+// survives class stripping: italic and bold become em and strong, line-through, superscript and
+// subscript become s, sup and sub, text alignment becomes the align attribute, and small caps and
+// underlines become utility classes. Original classes are replaced. Margins are left alone, side
+// margins are as common on chapter containers as they are on quotes
+func applySemanticFormatting(doc *html.Node, styles map[*html.Node]cssProperties) {
+	var walk func(n *html.Node, inherited inheritedFormatting)
+	walk = func(n *html.Node, inherited inheritedFormatting) {
+		if n.Type != html.ElementNode {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c, inherited)
+			}
+			return
+		}
+		if n.Namespace != "" {
+			// HTML wrappers don't belong inside MathML and SVG
+			removeAttr(n, "class")
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c, inherited)
+			}
+			return
+		}
+		properties := styles[n]
+		current := inherited
+		var wraps []string
+		var classes []string
+
+		if value, ok := properties["font-style"]; ok {
+			current.italic = value == "italic" || value == "oblique"
+		}
+		if italicElements[n.Data] {
+			current.italic, current.italicMarked = true, true
+		} else if current.italic && !current.italicMarked {
+			wraps = append(wraps, "em")
+			current.italicMarked = true
+		} else if !current.italic {
+			current.italicMarked = false
+		}
+
+		if value, ok := properties["font-weight"]; ok {
+			current.bold = isBoldWeight(value)
+		}
+		if boldElements[n.Data] {
+			current.bold, current.boldMarked = true, true
+		} else if current.bold && !current.boldMarked {
+			wraps = append(wraps, "strong")
+			current.boldMarked = true
+		} else if !current.bold {
+			current.boldMarked = false
+		}
+
+		lines := properties["text-decoration-line"]
+		if strings.Contains(lines, "line-through") && n.Data != "s" && n.Data != "del" && n.Data != "strike" {
+			wraps = append(wraps, "s")
+		}
+		if strings.Contains(lines, "underline") && n.Data != "u" && n.Data != "a" {
+			classes = append(classes, ClassUnderline)
+		}
+		switch properties["vertical-align"] {
+		case "super":
+			if n.Data != "sup" {
+				wraps = append(wraps, "sup")
+			}
+		case "sub":
+			if n.Data != "sub" {
+				wraps = append(wraps, "sub")
+			}
+		}
+		if properties["font-variant"] == "small-caps" || properties["font-variant-caps"] == "small-caps" {
+			classes = append(classes, ClassSmallCaps)
+		}
+		if align := properties["text-align"]; alignElements[n.Data] {
+			switch align {
+			case "center", "right", "justify":
+				setAttr(n, "align", align)
+			}
+		}
+
+		removeAttr(n, "class")
+		if len(classes) > 0 {
+			n.Attr = append(n.Attr, html.Attribute{Key: "class", Val: strings.Join(classes, " ")})
+		}
+
+		// inline children are inside the wrappers added below, block children start over
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && blockElements[c.Data] {
+				blockInherited := current
+				for _, wrap := range wraps {
+					switch wrap {
+					case "em":
+						blockInherited.italicMarked = false
+					case "strong":
+						blockInherited.boldMarked = false
+					}
+				}
+				walk(c, blockInherited)
+				continue
+			}
+			walk(c, current)
+		}
+		for i := len(wraps) - 1; i >= 0; i-- {
+			wrapInlineRuns(n, wraps[i])
+		}
+	}
+	walk(doc, inheritedFormatting{})
+}
+
+func isBoldWeight(value string) bool {
+	switch value {
+	case "bold", "bolder":
+		return true
+	}
+	weight, err := strconv.Atoi(value)
+	return err == nil && weight >= 600
+}
+
+// wrapInlineRuns wraps every run of inline children of n in a new tag element, so the wrapper
+// never ends up holding block elements
+func wrapInlineRuns(n *html.Node, tag string) {
+	var run []*html.Node
+	flush := func() {
+		hasContent := false
+		for _, c := range run {
+			if c.Type != html.TextNode || strings.TrimSpace(c.Data) != "" {
+				hasContent = true
+			}
+		}
+		if hasContent {
+			wrapper := &html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag))}
+			n.InsertBefore(wrapper, run[0])
+			for _, c := range run {
+				n.RemoveChild(c)
+				wrapper.AppendChild(c)
+			}
+		}
+		run = nil
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && blockElements[c.Data] {
+			flush()
+			continue
+		}
+		run = append(run, c)
+	}
+	flush()
+}
+
+func setAttr(n *html.Node, key string, value string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key && attr.Namespace == "" {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key && attr.Namespace == "" {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// note types reading systems show as popups, books hide them in the flow on purpose
+var noteTypes = setOf("footnote", "endnote", "rearnote", "note")
+
+// removeHiddenContent removes what the stylesheets, style attributes or the hidden attribute hide
+// from the body of doc: display:none elements, and the text and images of visibility:hidden ones
+func removeHiddenContent(doc *html.Node, styles map[*html.Node]cssProperties) {
+	var walk func(n *html.Node, invisible bool)
+	walk = func(n *html.Node, invisible bool) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch c.Type {
+			case html.ElementNode:
+				properties := styles[c]
+				if isHiddenElement(c, properties) {
+					n.RemoveChild(c)
+					break
+				}
+				childInvisible := invisible
+				if visibility, ok := properties["visibility"]; ok {
+					childInvisible = visibility == "hidden" || visibility == "collapse"
+				}
+				if childInvisible && (c.Data == "img" || c.Data == "svg") {
+					n.RemoveChild(c)
+					break
+				}
+				walk(c, childInvisible)
+			case html.TextNode:
+				if invisible {
+					n.RemoveChild(c)
+				}
+			}
+			c = next
+		}
+	}
+	if body := findElement(doc, "body"); body != nil {
+		walk(body, false)
+	}
+}
+
+func isHiddenElement(n *html.Node, properties cssProperties) bool {
+	if epubType, ok := getAttr(n, "epub:type"); ok {
+		for _, t := range strings.Fields(epubType) {
+			if noteTypes[t] {
+				return false
+			}
+		}
+	}
+	if _, ok := getAttr(n, "hidden"); ok {
+		return true
+	}
+	return properties["display"] == "none"
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// identifier types recognised by classifyIdentifier
+const (
+	IdentifierISBN13 = "isbn13"
+	IdentifierISBN10 = "isbn10"
+	IdentifierUUID   = "uuid"
+	IdentifierDOI    = "doi"
+	IdentifierASIN   = "asin"
+	IdentifierURL    = "url"
+	IdentifierOther  = "other"
+)
+
+// onix codelist 5 values used by the identifier-type refinement
+var onixIdentifierTypes = map[string]string{
+	"02": IdentifierISBN10,
+	"03": IdentifierISBN13, // GTIN-13, ISBN-13 when it starts with 978 or 979
+	"06": IdentifierDOI,
+	"15": IdentifierISBN13,
+}
+
+var (
+	uuidPattern = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	doiPattern  = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+	asinPattern = regexp.MustCompile(`^B0[0-9A-Z]{8}$`)
+)
+
+type BookIdentifier struct {
+	Value      string // identifier as written in the package document
+	Scheme     string // opf:scheme or identifier-type refinement as written
+	Type       string // one of the Identifier* constants
+	Normalized string // ISBN-13 digits for ISBNs, lower case UUID, bare DOI, otherwise the value
+	Valid      bool   // false for ISBNs with a bad checksum
+	Unique     bool   // the package unique-identifier
+}
+
+// getIdentifierScheme3 returns the identifier-type refinement, falling back to the urn namespace of value
+func getIdentifierScheme3(metaMap map[string]map[string]Meta, id string, value string) string {
+	scheme := strings.TrimSpace(getMetadata(metaMap, id, "identifier-type"))
+	if scheme != "" {
+		if getMetadataSchema(metaMap, id, "identifier-type") == "onix:codelist5" {
+			if t, ok := onixIdentifierTypes[scheme]; ok {
+				return t
+			}
+		}
+		return scheme
+	}
+	lower := strings.ToLower(value)
+	if strings.HasPrefix(lower, "urn:") {
+		if parts := strings.SplitN(lower, ":", 3); len(parts) == 3 {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+func getBookIdentifiers(identifiers *[]ID, mainId *Identifier) []BookIdentifier {
+	if identifiers == nil {
+		return nil
+	}
+	var result []BookIdentifier
+	for _, identifier := range *identifiers {
+		if identifier.Id == "" {
+			continue
+		}
+		scheme := ""
+		if identifier.Scheme != nil {
+			scheme = *identifier.Scheme
+		}
+		bookIdentifier := classifyIdentifier(identifier.Id, scheme)
+		bookIdentifier.Unique = mainId != nil && mainId.Id == identifier.Id
+		result = append(result, bookIdentifier)
+	}
+	return result
+}
+
+// getIsbn returns the first valid ISBN as ISBN-13, preferring the unique identifier
+func getIsbn(identifiers []BookIdentifier) string {
+	isbn := ""
+	for _, identifier := range identifiers {
+		if !identifier.Valid || (identifier.Type != IdentifierISBN13 && identifier.Type != IdentifierISBN10) {
+			continue
+		}
+		if identifier.Unique {
+			return identifier.Normalized
+		}
+		if isbn == "" {
+			isbn = identifier.Normalized
+		}
+	}
+	return isbn
+}
+
+// classifyIdentifier works out the identifier type from the declared scheme first and the value second
+func classifyIdentifier(value string, scheme string) BookIdentifier {
+	value = strings.TrimSpace(value)
+	identifier := BookIdentifier{Value: value, Scheme: scheme, Normalized: value, Valid: true}
+	lower := strings.ToLower(value)
+	lowerScheme := strings.ToLower(strings.TrimSpace(scheme))
+
+	bare := value
+	for _, prefix := range []string{"urn:isbn:", "isbn:", "isbn ", "urn:uuid:", "uuid:", "urn:doi:", "doi:", "https://doi.org/", "http://dx.doi.org/", "urn:asin:", "asin:"} {
+		if strings.HasPrefix(lower, prefix) {
+			bare = strings.TrimSpace(value[len(prefix):])
+			if lowerScheme == "" {
+				lowerScheme = strings.Trim(strings.TrimPrefix(prefix, "urn:"), ": /")
+			}
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(lowerScheme, "isbn") || lowerScheme == IdentifierISBN13 || lowerScheme == IdentifierISBN10:
+		identifier.Type, identifier.Normalized, identifier.Valid = classifyIsbn(bare)
+		if identifier.Type == "" {
+			identifier.Type = IdentifierISBN13
+		}
+	case strings.Contains(lowerScheme, "uuid") || uuidPattern.MatchString(bare):
+		identifier.Type = IdentifierUUID
+		identifier.Normalized = strings.ToLower(bare)
+	case strings.Contains(lowerScheme, "doi") || doiPattern.MatchString(bare):
+		identifier.Type = IdentifierDOI
+		identifier.Normalized = bare
+	case strings.Contains(lowerScheme, "asin") || lowerScheme == "amazon" || asinPattern.MatchString(bare):
+		identifier.Type = IdentifierASIN
+		identifier.Normalized = strings.ToUpper(bare)
+	case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"):
+		identifier.Type = IdentifierURL
+	default:
+		// plenty of EPUB 2 books put a bare ISBN in an identifier with a scheme like "calibre" or none at all
+		if t, normalized, valid := classifyIsbn(bare); t != "" && valid {
+			identifier.Type, identifier.Normalized = t, normalized
+		} else {
+			identifier.Type = IdentifierOther
+		}
+	}
+	return identifier
+}
+
+// classifyIsbn returns the ISBN type, the ISBN-13 form and whether the checksum is valid.
+// The type is empty when the value doesn't have the shape of an ISBN at all
+func classifyIsbn(value string) (string, string, bool) {
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == 'x' || r == 'X':
+			return 'X'
+		case r == '-' || r == ' ':
+			return -1
+		}
+		return '?'
+	}, value)
+	if strings.Contains(digits, "?") {
+		return "", value, false
+	}
+
+	switch len(digits) {
+	case 13:
+		if strings.Contains(digits, "X") || !(strings.HasPrefix(digits, "978") || strings.HasPrefix(digits, "979")) {
+			return "", value, false
+		}
+		return IdentifierISBN13, digits, isbn13Checksum(digits[:12]) == digits[12]
+	case 10:
+		if strings.Contains(digits[:9], "X") {
+			return "", value, false
+		}
+		valid := isbn10Checksum(digits[:9]) == digits[9]
+		isbn13 := "978" + digits[:9]
+		return IdentifierISBN10, isbn13 + string(isbn13Checksum(isbn13)), valid
+	}
+	return "", value, false
+}
+
+func isbn10Checksum(digits string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+func isbn13Checksum(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
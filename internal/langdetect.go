@@ -0,0 +1,315 @@
+package parser
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// profiles holds the n-gram rank profile of each language, most frequent n-gram first with spaces
+// written as underscores, see profiles/generate.go
+//
+//go:embed profiles/*.txt
+var profiles embed.FS
+
+const (
+	profileSize = 2000
+	// detection on less text than this is little better than a guess
+	minDetectionLetters = 200
+	// mismatches are only reported above this confidence
+	mismatchConfidence = 0.1
+	// chapter text sampled for detection
+	detectionSampleChapters = 8
+	detectionSampleChars    = 4000
+)
+
+type LanguageDetection struct {
+	Declared   string  // BCP 47 form of the declared language, empty when none
+	Detected   string  // detected base language, empty when the text was too short
+	Confidence float64 // 0 to 1, how far the best language is ahead of the runner-up
+	Mismatch   bool    // declared and detected base languages differ
+}
+
+var (
+	languageProfilesOnce sync.Once
+	languageProfiles     map[string]map[string]int
+)
+
+func loadLanguageProfiles() {
+	languageProfiles = make(map[string]map[string]int)
+	entries, err := profiles.ReadDir("profiles")
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		data, err := profiles.ReadFile(path.Join("profiles", entry.Name()))
+		if err != nil {
+			continue
+		}
+		lang := strings.TrimSuffix(entry.Name(), ".txt")
+		languageProfiles[lang] = parseNgramProfile(string(data))
+	}
+}
+
+// parseNgramProfile reads the first profileSize n-grams of a profile file into their ranks
+func parseNgramProfile(data string) map[string]int {
+	ranks := make(map[string]int, profileSize)
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		if len(ranks) == profileSize {
+			break
+		}
+		ranks[strings.ReplaceAll(line, "_", " ")] = len(ranks)
+	}
+	return ranks
+}
+
+// buildNgramProfile ranks the 1 to 3 character n-grams of text by frequency (Cavnar & Trenkle)
+func buildNgramProfile(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + word + " ")
+		for n := 1; n <= 3; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if gram == " " {
+					continue
+				}
+				counts[gram]++
+			}
+		}
+	}
+
+	grams := make([]string, 0, len(counts))
+	for gram := range counts {
+		grams = append(grams, gram)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if counts[grams[i]] != counts[grams[j]] {
+			return counts[grams[i]] > counts[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if len(grams) > profileSize {
+		grams = grams[:profileSize]
+	}
+	ranks := make(map[string]int, len(grams))
+	for i, gram := range grams {
+		ranks[gram] = i
+	}
+	return ranks
+}
+
+// scriptLanguages covers the scripts that identify a language on their own
+var scriptLanguages = []struct {
+	script *unicode.RangeTable
+	lang   string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// detectScriptLanguage handles text that isn't mostly Latin, returning false for Latin text
+func detectScriptLanguage(text string) (string, float64, bool) {
+	letters, latin, han, kana, cyrillic := 0, 0, 0, 0, 0
+	scripts := make([]int, len(scriptLanguages))
+	ukrainian := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+			if strings.ContainsRune("іїєґІЇЄҐ", r) {
+				ukrainian++
+			}
+		default:
+			for i, s := range scriptLanguages {
+				if unicode.Is(s.script, r) {
+					scripts[i]++
+					break
+				}
+			}
+		}
+	}
+	if letters == 0 || latin*2 > letters {
+		return "", 0, false
+	}
+	share := func(n int) float64 { return float64(n) / float64(letters) }
+	switch {
+	case kana > 0 && kana+han > letters/2:
+		return "ja", share(kana + han), true
+	case han > letters/2:
+		return "zh", share(han), true
+	case cyrillic > letters/2:
+		if ukrainian*100 > cyrillic {
+			return "uk", share(cyrillic), true
+		}
+		return "ru", share(cyrillic), true
+	}
+	for i, s := range scriptLanguages {
+		if scripts[i] > letters/2 {
+			return s.lang, share(scripts[i]), true
+		}
+	}
+	return "", 0, false
+}
+
+// detectLanguage returns the most likely base language of text and a confidence between 0 and 1
+func detectLanguage(text string) (string, float64) {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minDetectionLetters {
+		return "", 0
+	}
+	if lang, confidence, ok := detectScriptLanguage(text); ok {
+		return lang, confidence
+	}
+
+	languageProfilesOnce.Do(loadLanguageProfiles)
+	textProfile := buildNgramProfile(text)
+	best, bestDistance, secondDistance := "", -1, -1
+	for lang, profile := range languageProfiles {
+		distance := 0
+		for gram, rank := range textProfile {
+			if langRank, ok := profile[gram]; ok {
+				if langRank > rank {
+					distance += langRank - rank
+				} else {
+					distance += rank - langRank
+				}
+			} else {
+				distance += profileSize
+			}
+		}
+		switch {
+		case bestDistance < 0 || distance < bestDistance || (distance == bestDistance && lang < best):
+			secondDistance = bestDistance
+			best, bestDistance = lang, distance
+		case secondDistance < 0 || distance < secondDistance:
+			secondDistance = distance
+		}
+	}
+	if best == "" || secondDistance <= 0 {
+		return best, 0
+	}
+	return best, float64(secondDistance-bestDistance) / float64(secondDistance)
+}
+
+// sampleChapterText takes text from chapters spread over the book, skipping the first and last
+// chapters of longer books since front and back matter is often in another language
+func sampleChapterText(texts []Content) string {
+	chapters := texts
+	if len(chapters) > 4 {
+		chapters = chapters[1 : len(chapters)-1]
+	}
+	step := 1
+	if len(chapters) > detectionSampleChapters {
+		step = len(chapters) / detectionSampleChapters
+	}
+	var sample strings.Builder
+	for i := 0; i < len(chapters); i += step {
+		text := chapters[i].Text
+		if len(text) > detectionSampleChars {
+			// cut on a rune boundary, a split character would reach the detector as an invalid one
+			end := detectionSampleChars
+			for end > 0 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+			text = text[:end]
+		}
+		sample.WriteString(text)
+		sample.WriteString("\n")
+	}
+	return sample.String()
+}
+
+func getLanguageDetection(texts []Content, declared string) LanguageDetection {
+	detected, confidence := detectLanguage(sampleChapterText(texts))
+	detection := LanguageDetection{
+		Declared:   declared,
+		Detected:   detected,
+		Confidence: confidence,
+	}
+	if declared != "" && detected != "" && confidence >= mismatchConfidence {
+		if tag, ok := normalizeLanguage(declared); ok {
+			base, _ := tag.Base()
+			detection.Mismatch = base.String() != detected && isDetectable(base.String())
+		}
+	}
+	return detection
+}
+
+// isDetectable reports whether detectLanguage can ever return lang, a book declared in a
+// language without a profile would otherwise always look like a mismatch
+func isDetectable(lang string) bool {
+	switch lang {
+	case "ja", "zh", "ru", "uk":
+		return true
+	}
+	for _, s := range scriptLanguages {
+		if s.lang == lang {
+			return true
+		}
+	}
+	languageProfilesOnce.Do(loadLanguageProfiles)
+	_, ok := languageProfiles[lang]
+	return ok
+}
+
+// getBookLanguage returns the language the book is in: the first declared language,
+// or the detected one when nothing usable is declared. Mismatches end up in the diagnostics
+func getBookLanguage(texts []Content, declared []language.Tag, opts Options) (language.Tag, *LanguageDetection, []Diagnostic) {
+	primary := language.Und
+	if len(declared) > 0 {
+		primary = declared[0]
+	}
+	if opts.SkipLanguageDetection {
+		return primary, nil, nil
+	}
+
+	declaredString := ""
+	if primary != language.Und {
+		declaredString = primary.String()
+	}
+	detection := getLanguageDetection(texts, declaredString)
+
+	var diagnostics []Diagnostic
+	if detection.Mismatch {
+		diagnostics = append(diagnostics, Diagnostic{
+			Code:    DiagnosticLanguageMismatch,
+			Message: fmt.Sprintf("declared language %s but the text looks like %s (confidence %.2f)", detection.Declared, detection.Detected, detection.Confidence),
+		})
+	}
+	if primary == language.Und && detection.Detected != "" && detection.Confidence >= mismatchConfidence {
+		if tag, ok := normalizeLanguage(detection.Detected); ok {
+			primary = tag
+		}
+	}
+	return primary, &detection, diagnostics
+}
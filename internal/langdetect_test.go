@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_detect_language(t *testing.T) {
+	cases := map[string]string{
+		"en": "It was the best of times, it was the worst of times, it was the age of wisdom, it was the age of foolishness, it was the epoch of belief, it was the epoch of incredulity, it was the season of light, it was the season of darkness, it was the spring of hope, it was the winter of despair.",
+		"es": "En un lugar de la Mancha, de cuyo nombre no quiero acordarme, no ha mucho tiempo que vivía un hidalgo de los de lanza en astillero, adarga antigua, rocín flaco y galgo corredor. Una olla de algo más vaca que carnero, salpicón las más noches, duelos y quebrantos los sábados.",
+		"fr": "Longtemps, je me suis couché de bonne heure. Parfois, à peine ma bougie éteinte, mes yeux se fermaient si vite que je n'avais pas le temps de me dire : je m'endors. Et, une demi-heure après, la pensée qu'il était temps de chercher le sommeil m'éveillait; je voulais poser le volume que je croyais avoir encore dans les mains et souffler ma lumière.",
+		"de": "Als Gregor Samsa eines Morgens aus unruhigen Träumen erwachte, fand er sich in seinem Bett zu einem ungeheueren Ungeziefer verwandelt. Er lag auf seinem panzerartig harten Rücken und sah, wenn er den Kopf ein wenig hob, seinen gewölbten, braunen Bauch.",
+		"it": "Nel mezzo del cammin di nostra vita mi ritrovai per una selva oscura, ché la diritta via era smarrita. Ahi quanto a dir qual era è cosa dura esta selva selvaggia e aspra e forte che nel pensier rinova la paura! Tant'è amara che poco è più morte; ma per trattar del ben ch'i' vi trovai, dirò de l'altre cose ch'i' v'ho scorte.",
+		"pt": "Uma noite destas, vindo da cidade para o Engenho Novo, encontrei no trem da Central um rapaz aqui do bairro, que eu conheço de vista e de chapéu. Cumprimentou-me, sentou-se ao pé de mim, falou da lua e dos ministros, e acabou recitando-me versos. A viagem era curta, e os versos pode ser que não fossem inteiramente maus.",
+		"nl": "Ik ben makelaar in koffie, en woon op de Lauriergracht, No. 37. Het is mijn gewoonte niet, romans te schrijven, of zulke dingen, en het heeft dan ook lang geduurd, voor ik er toe overging een paar riem papier extra te bestellen, en het werk aan te vangen, dat gij, lieve lezer, zoo even in de hand hebt genomen.",
+		"ru": "Все счастливые семьи похожи друг на друга, каждая несчастливая семья несчастлива по-своему. Всё смешалось в доме Облонских. Жена узнала, что муж был в связи с бывшею в их доме француженкою-гувернанткой, и объявила мужу, что не может жить с ним в одном доме.",
+	}
+	for expected, text := range cases {
+		detected, confidence := detectLanguage(text)
+		if detected != expected {
+			t.Logf("expected %s but detected %s (confidence %.2f)", expected, detected, confidence)
+			t.Fail()
+		}
+	}
+
+	for _, lang := range []string{"es", "pt", "it", "fr"} {
+		detection := getLanguageDetection([]Content{{Text: cases[lang]}}, "en")
+		if !detection.Mismatch || detection.Detected != lang {
+			t.Logf("expected a mismatch for %s text declared as english but got %+v", lang, detection)
+			t.Fail()
+		}
+	}
+	detection := getLanguageDetection([]Content{{Text: cases["en"]}}, "en-GB")
+	if detection.Mismatch {
+		t.Logf("unexpected mismatch for english text %+v", detection)
+		t.Fail()
+	}
+}
+
+func Test_detect_spanish_portuguese(t *testing.T) {
+	cases := map[string]string{
+		"es": "Muchos años después, frente al pelotón de fusilamiento, el coronel Aureliano Buendía había de recordar aquella tarde remota en que su padre lo llevó a conocer el hielo. Macondo era entonces una aldea de veinte casas de barro y cañabrava construidas a la orilla de un río de aguas diáfanas que se precipitaban por un lecho de piedras pulidas, blancas y enormes como huevos prehistóricos.",
+		"pt": "Ao vencedor, as batatas. Não te irrites se te pagarem mal um benefício; antes cair das nuvens que de um terceiro andar. Quincas Borba não morreu logo; viveu ainda alguns meses, e os médicos, que o examinaram, acharam que a doença não tinha cura, mas que a vida podia prolongar-se por algum tempo, se ele tivesse os cuidados necessários.",
+	}
+	declared := map[string]string{"es": "pt", "pt": "es"}
+	for expected, text := range cases {
+		detection := getLanguageDetection([]Content{{Text: text}}, declared[expected])
+		if detection.Detected != expected || !detection.Mismatch {
+			t.Logf("expected %s text declared as %s to be a mismatch but got %+v", expected, declared[expected], detection)
+			t.Fail()
+		}
+	}
+}
+
+func Test_sample_rune_boundary(t *testing.T) {
+	// "é" is two bytes, an odd offset puts the sample cut in the middle of one
+	text := "a" + strings.Repeat("é", detectionSampleChars)
+	if sample := sampleChapterText([]Content{{Text: text}}); !utf8.ValidString(sample) {
+		t.Logf("expected the sample cut on a rune boundary but it ends with %q", sample[len(sample)-4:])
+		t.Fail()
+	}
+}
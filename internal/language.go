@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// LanguageResolver maps a BCP 47 tag to the application's language id
+type LanguageResolver interface {
+	ResolveLanguageID(tag language.Tag) (int, bool)
+}
+
+// TableLanguageResolver looks the tag up by its full BCP 47 form first and by its base language second,
+// so "pt-BR" resolves to the "pt-BR" entry when there is one and to "pt" otherwise
+type TableLanguageResolver struct {
+	IDs map[string]int
+}
+
+func (r TableLanguageResolver) ResolveLanguageID(tag language.Tag) (int, bool) {
+	if id, ok := r.IDs[tag.String()]; ok {
+		return id, true
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No {
+		return 0, false
+	}
+	id, ok := r.IDs[base.String()]
+	return id, ok
+}
+
+// DefaultLanguageIDs is the table used when no resolver is configured
+var DefaultLanguageIDs = map[string]int{
+	"en": 1,
+	"es": 2,
+	"fr": 3,
+	"de": 4,
+	"it": 5,
+	"pt": 6,
+	"nl": 7,
+	"ru": 8,
+	"zh": 9,
+	"ja": 10,
+	"ko": 11,
+	"ar": 12,
+	"pl": 13,
+	"sv": 14,
+	"tr": 15,
+	"el": 16,
+	"he": 17,
+	"hi": 18,
+	"ca": 19,
+	"da": 20,
+	"no": 21,
+	"fi": 22,
+	"cs": 23,
+	"uk": 24,
+}
+
+var (
+	languageNamesOnce sync.Once
+	languageNames     map[string]language.Tag
+)
+
+// loadLanguageNames indexes the English and native names of every ISO 639-1 language,
+// publishers regularly write "English" or "Español" in dc:language
+func loadLanguageNames() {
+	languageNames = make(map[string]language.Tag)
+	for a := 'a'; a <= 'z'; a++ {
+		for b := 'a'; b <= 'z'; b++ {
+			base, err := language.ParseBase(string([]rune{a, b}))
+			if err != nil {
+				continue
+			}
+			tag, err := language.Compose(base)
+			if err != nil {
+				continue
+			}
+			for _, name := range []string{display.English.Languages().Name(tag), display.Self.Name(tag)} {
+				if name == "" {
+					continue
+				}
+				key := strings.ToLower(name)
+				if _, exists := languageNames[key]; !exists {
+					languageNames[key] = tag
+				}
+			}
+		}
+	}
+}
+
+// normalizeLanguage turns "eng", "en_US", "English" and friends into a canonical BCP 47 tag
+func normalizeLanguage(raw string) (language.Tag, bool) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return language.Und, false
+	}
+	tag, err := language.Parse(strings.ReplaceAll(value, "_", "-"))
+	if err == nil && tag != language.Und {
+		return tag, true
+	}
+	languageNamesOnce.Do(loadLanguageNames)
+	if tag, ok := languageNames[strings.ToLower(value)]; ok {
+		return tag, true
+	}
+	return language.Und, false
+}
+
+// getLanguageTags normalizes every declared language, dropping duplicates and values that aren't languages
+func getLanguageTags(languages *[]ID) []language.Tag {
+	if languages == nil {
+		return nil
+	}
+	var tags []language.Tag
+	seen := make(map[string]bool)
+	for _, l := range *languages {
+		tag, ok := normalizeLanguage(l.Text)
+		if !ok || seen[tag.String()] {
+			continue
+		}
+		seen[tag.String()] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func languageStrings(tags []language.Tag) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.String()
+	}
+	return result
+}
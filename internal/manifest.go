@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// manifest item properties defined by EPUB 3
+const (
+	PropertyNav             = "nav"
+	PropertyCoverImage      = "cover-image"
+	PropertyScripted        = "scripted"
+	PropertyMathML          = "mathml"
+	PropertySVG             = "svg"
+	PropertyRemoteResources = "remote-resources"
+)
+
+type ManifestItem struct {
+	Id         string
+	Href       string // full path inside the archive
+	MediaType  string
+	Properties []string
+	Fallback   string
+}
+
+// HasProperty reports whether the manifest item declares property p
+func (m ManifestItem) HasProperty(p string) bool {
+	for _, prop := range m.Properties {
+		if prop == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (item Item) hasProperty(p string) bool {
+	for _, prop := range strings.Fields(item.Properties) {
+		if prop == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isContentDocument reports whether a spine item of this media type can be rendered directly
+func isContentDocument(mediaType string) bool {
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "application/xhtml+xml", "text/html", "text/x-oeb1-document", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// resolveFallback follows the fallback chain of item until it reaches a content document.
+// The chain is guarded against cycles, returns false if no renderable item is found
+func resolveFallback(item Item, manifestIDItemMap map[string]Item) (Item, bool) {
+	seen := make(map[string]bool)
+	for {
+		if isContentDocument(item.MediaType) {
+			return item, true
+		}
+		seen[item.Id] = true
+		if item.Fallback == nil || *item.Fallback == "" || seen[*item.Fallback] {
+			return Item{}, false
+		}
+		next, ok := manifestIDItemMap[*item.Fallback]
+		if !ok {
+			return Item{}, false
+		}
+		item = next
+	}
+}
+
+func getManifestItems(manifestItems []Item, rootDir string) []ManifestItem {
+	items := make([]ManifestItem, len(manifestItems))
+	for i, item := range manifestItems {
+		fallback := ""
+		if item.Fallback != nil {
+			fallback = *item.Fallback
+		}
+		items[i] = ManifestItem{
+			Id:         item.Id,
+			Href:       filepath.Join(rootDir, item.Href),
+			MediaType:  item.MediaType,
+			Properties: strings.Fields(item.Properties),
+			Fallback:   fallback,
+		}
+	}
+	return items
+}
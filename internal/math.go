@@ -0,0 +1,404 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ClassMath marks the elements holding the LaTeX of a formula in MathLaTeX mode
+const ClassMath = "math"
+
+var texCommandEnd = regexp.MustCompile(`\\[a-zA-Z]+$`)
+
+// texSymbols maps the characters of mi and mo elements to LaTeX
+var texSymbols = map[string]string{
+	"α": `\alpha`, "β": `\beta`, "γ": `\gamma`, "δ": `\delta`, "ε": `\epsilon`, "ϵ": `\epsilon`,
+	"ζ": `\zeta`, "η": `\eta`, "θ": `\theta`, "ϑ": `\vartheta`, "ι": `\iota`, "κ": `\kappa`,
+	"λ": `\lambda`, "μ": `\mu`, "ν": `\nu`, "ξ": `\xi`, "π": `\pi`, "ϖ": `\varpi`, "ρ": `\rho`,
+	"ϱ": `\varrho`, "σ": `\sigma`, "ς": `\varsigma`, "τ": `\tau`, "υ": `\upsilon`, "φ": `\phi`,
+	"ϕ": `\phi`, "χ": `\chi`, "ψ": `\psi`, "ω": `\omega`,
+	"Γ": `\Gamma`, "Δ": `\Delta`, "Θ": `\Theta`, "Λ": `\Lambda`, "Ξ": `\Xi`, "Π": `\Pi`,
+	"Σ": `\Sigma`, "Υ": `\Upsilon`, "Φ": `\Phi`, "Ψ": `\Psi`, "Ω": `\Omega`,
+	"×": `\times`, "÷": `\div`, "±": `\pm`, "∓": `\mp`, "⋅": `\cdot`, "·": `\cdot`, "∗": `*`,
+	"−": `-`, "≤": `\leq`, "≥": `\geq`, "≠": `\neq`, "≈": `\approx`, "≡": `\equiv`, "∼": `\sim`,
+	"≅": `\cong`, "∝": `\propto`, "∞": `\infty`, "∑": `\sum`, "∏": `\prod`, "∫": `\int`,
+	"∬": `\iint`, "∮": `\oint`, "∂": `\partial`, "∇": `\nabla`, "→": `\to`, "←": `\leftarrow`,
+	"↔": `\leftrightarrow`, "⇒": `\Rightarrow`, "⇐": `\Leftarrow`, "⇔": `\Leftrightarrow`,
+	"∈": `\in`, "∉": `\notin`, "∋": `\ni`, "⊂": `\subset`, "⊃": `\supset`, "⊆": `\subseteq`,
+	"⊇": `\supseteq`, "∪": `\cup`, "∩": `\cap`, "∅": `\emptyset`, "∀": `\forall`, "∃": `\exists`,
+	"¬": `\neg`, "∧": `\wedge`, "∨": `\vee`, "…": `\ldots`, "⋯": `\cdots`, "⋮": `\vdots`,
+	"∘": `\circ`, "°": `^{\circ}`, "′": `'`, "″": `''`, "⊥": `\perp`, "∥": `\parallel`,
+	"∠": `\angle`, "√": `\surd`, "ℏ": `\hbar`, "ℓ": `\ell`, "ℝ": `\mathbb{R}`, "ℕ": `\mathbb{N}`,
+	"ℤ": `\mathbb{Z}`, "ℚ": `\mathbb{Q}`, "ℂ": `\mathbb{C}`, "⟨": `\langle`, "⟩": `\rangle`,
+	"{": `\{`, "}": `\}`, "⁡": "", "⁢": "", "⁣": "", "⁤": "",
+}
+
+// texFunctions are the multi-letter identifiers LaTeX has an operator for
+var texFunctions = setOf(
+	"sin", "cos", "tan", "cot", "sec", "csc", "arcsin", "arccos", "arctan", "sinh", "cosh", "tanh",
+	"log", "ln", "lg", "exp", "lim", "max", "min", "sup", "inf", "det", "dim", "ker", "gcd", "deg", "arg",
+)
+
+// texLargeOperators take their under and over scripts as limits
+var texLargeOperators = setOf(`\sum`, `\prod`, `\int`, `\iint`, `\oint`, `\lim`, `\max`, `\min`, `\sup`, `\inf`, `\cup`, `\cap`)
+
+var (
+	texOverAccents = map[string]string{
+		"¯": `\overline`, "‾": `\overline`, "―": `\overline`, "^": `\hat`, "ˆ": `\hat`,
+		"~": `\tilde`, "˜": `\tilde`, "→": `\vec`, "⃗": `\vec`, "˙": `\dot`, "¨": `\ddot`, "⏞": `\overbrace`,
+	}
+	texUnderAccents = map[string]string{"_": `\underline`, "̲": `\underline`, "⏟": `\underbrace`}
+	texVariants     = map[string]string{
+		"bold": `\mathbf`, "double-struck": `\mathbb`, "script": `\mathcal`, "fraktur": `\mathfrak`,
+	}
+)
+
+// getMathText is the plain text of a math element: its alttext, a text annotation such as its
+// TeX source, or the characters of its presentation markup
+func getMathText(math *html.Node) string {
+	if alt, ok := getAttr(math, "alttext"); ok && strings.TrimSpace(alt) != "" {
+		return strings.TrimSpace(alt)
+	}
+	if annotation, ok := getMathAnnotation(math, false); ok {
+		return annotation
+	}
+	var tokens []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "annotation" || n.Data == "annotation-xml") {
+			return
+		}
+		if n.Type == html.TextNode {
+			tokens = append(tokens, strings.Fields(n.Data)...)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(math)
+	return strings.Join(tokens, " ")
+}
+
+// getMathAnnotation returns the first annotation of the semantics of math, only TeX ones when tex is set
+func getMathAnnotation(math *html.Node, tex bool) (string, bool) {
+	var annotation string
+	found := false
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && !found; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "semantics":
+				walk(c)
+			case "annotation":
+				encoding, _ := getAttr(c, "encoding")
+				if tex && !strings.Contains(strings.ToLower(encoding), "tex") {
+					continue
+				}
+				if text := strings.TrimSpace(getTextContent(c)); text != "" {
+					annotation, found = text, true
+				}
+			}
+		}
+	}
+	walk(math)
+	return annotation, found
+}
+
+// replaceMathWithLaTeX replaces every math element of doc with a span holding its LaTeX between
+// \( \) or, for display math, \[ \] delimiters. A TeX annotation is used as is, presentation
+// MathML is converted
+func replaceMathWithLaTeX(doc *html.Node) {
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data != "math" {
+				walk(c)
+				continue
+			}
+			latex, ok := getMathAnnotation(c, true)
+			if !ok {
+				latex = mathToLaTeX(c)
+			}
+			if display, _ := getAttr(c, "display"); display == "block" {
+				latex = `\[` + latex + `\]`
+			} else {
+				latex = `\(` + latex + `\)`
+			}
+			span := &html.Node{Type: html.ElementNode, Data: "span", DataAtom: atom.Span,
+				Attr: []html.Attribute{{Key: "class", Val: ClassMath}}}
+			span.AppendChild(&html.Node{Type: html.TextNode, Data: latex})
+			n.InsertBefore(span, c)
+			n.RemoveChild(c)
+			c = span
+		}
+	}
+	walk(doc)
+}
+
+// mathToLaTeX converts presentation MathML, elements it doesn't know contribute their children
+func mathToLaTeX(n *html.Node) string {
+	children := mathChildren(n)
+	child := func(i int) string {
+		if i < len(children) {
+			return mathToLaTeX(children[i])
+		}
+		return ""
+	}
+	switch n.Data {
+	case "mi":
+		return mathIdentifier(n)
+	case "mn":
+		return texEscape(strings.TrimSpace(getTextContent(n)))
+	case "mo":
+		return mathOperator(strings.TrimSpace(getTextContent(n)))
+	case "mtext":
+		if text := getTextContent(n); strings.TrimSpace(text) != "" {
+			return `\text{` + texEscape(text) + `}`
+		}
+		return `\ `
+	case "ms":
+		return `\text{"` + texEscape(getTextContent(n)) + `"}`
+	case "mspace":
+		return `\ `
+	case "mfrac":
+		return `\frac{` + child(0) + `}{` + child(1) + `}`
+	case "msqrt":
+		return `\sqrt{` + mathRow(children) + `}`
+	case "mroot":
+		return `\sqrt[` + child(1) + `]{` + child(0) + `}`
+	case "msup":
+		return texGroup(child(0)) + `^{` + child(1) + `}`
+	case "msub":
+		return texGroup(child(0)) + `_{` + child(1) + `}`
+	case "msubsup":
+		return texGroup(child(0)) + `_{` + child(1) + `}^{` + child(2) + `}`
+	case "mover", "munder", "munderover":
+		return mathUnderOver(n.Data, child(0), children)
+	case "mmultiscripts":
+		return mathMultiscripts(children)
+	case "mfenced":
+		return mathFenced(n, children)
+	case "mtable":
+		var rows []string
+		for _, row := range children {
+			cells := mathChildren(row)
+			if row.Data == "mlabeledtr" && len(cells) > 0 {
+				cells = cells[1:]
+			}
+			var latex []string
+			for _, cell := range cells {
+				latex = append(latex, mathToLaTeX(cell))
+			}
+			rows = append(rows, strings.Join(latex, " & "))
+		}
+		return `\begin{matrix}` + strings.Join(rows, ` \\ `) + `\end{matrix}`
+	case "mphantom":
+		return `\phantom{` + mathRow(children) + `}`
+	case "semantics", "maction":
+		return child(0)
+	case "annotation", "annotation-xml", "mprescripts", "none":
+		return ""
+	}
+	return mathRow(children)
+}
+
+// mathChildren returns the element children of n
+func mathChildren(n *html.Node) []*html.Node {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+// mathRow joins the LaTeX of nodes, separating a command from a letter following it
+func mathRow(nodes []*html.Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		latex := mathToLaTeX(n)
+		if latex == "" {
+			continue
+		}
+		first, _ := utf8.DecodeRuneInString(latex)
+		if unicode.IsLetter(first) && texCommandEnd.MatchString(b.String()) {
+			b.WriteString(" ")
+		}
+		b.WriteString(latex)
+	}
+	return b.String()
+}
+
+func mathIdentifier(n *html.Node) string {
+	text := strings.TrimSpace(getTextContent(n))
+	variant, _ := getAttr(n, "mathvariant")
+	latex := texEscape(text)
+	if symbol, ok := texSymbols[text]; ok {
+		latex = symbol
+	} else if utf8.RuneCountInString(text) > 1 {
+		if texFunctions[text] {
+			return `\` + text
+		}
+		if variant == "" {
+			variant = "normal"
+		}
+	}
+	if command, ok := texVariants[variant]; ok {
+		return command + `{` + latex + `}`
+	}
+	if variant == "normal" && latex != "" && !strings.HasPrefix(latex, `\`) {
+		return `\mathrm{` + latex + `}`
+	}
+	return latex
+}
+
+func mathOperator(text string) string {
+	if symbol, ok := texSymbols[text]; ok {
+		return symbol
+	}
+	if texFunctions[text] {
+		return `\` + text
+	}
+	return texEscape(text)
+}
+
+func mathUnderOver(tag string, base string, children []*html.Node) string {
+	var under, over string
+	switch tag {
+	case "mover":
+		if len(children) > 1 {
+			over = mathToLaTeX(children[1])
+		}
+	case "munder":
+		if len(children) > 1 {
+			under = mathToLaTeX(children[1])
+		}
+	case "munderover":
+		if len(children) > 2 {
+			under, over = mathToLaTeX(children[1]), mathToLaTeX(children[2])
+		}
+	}
+	if texLargeOperators[base] {
+		latex := base
+		if under != "" {
+			latex += `_{` + under + `}`
+		}
+		if over != "" {
+			latex += `^{` + over + `}`
+		}
+		return latex
+	}
+	if tag == "mover" && len(children) > 1 {
+		if accent, ok := texOverAccents[strings.TrimSpace(getTextContent(children[1]))]; ok {
+			return accent + `{` + base + `}`
+		}
+	}
+	if tag == "munder" && len(children) > 1 {
+		if accent, ok := texUnderAccents[strings.TrimSpace(getTextContent(children[1]))]; ok {
+			return accent + `{` + base + `}`
+		}
+	}
+	latex := base
+	if over != "" {
+		latex = `\overset{` + over + `}{` + latex + `}`
+	}
+	if under != "" {
+		latex = `\underset{` + under + `}{` + latex + `}`
+	}
+	return latex
+}
+
+// mathMultiscripts writes the base followed by its sub and superscript pairs, the pairs after
+// mprescripts go before the base
+func mathMultiscripts(children []*html.Node) string {
+	if len(children) == 0 {
+		return ""
+	}
+	var post, pre strings.Builder
+	scripts := &post
+	for i := 1; i < len(children); i++ {
+		if children[i].Data == "mprescripts" {
+			scripts = &pre
+			continue
+		}
+		if i+1 >= len(children) {
+			break
+		}
+		if sub := mathToLaTeX(children[i]); sub != "" {
+			scripts.WriteString(`_{` + sub + `}`)
+		}
+		if sup := mathToLaTeX(children[i+1]); sup != "" {
+			scripts.WriteString(`^{` + sup + `}`)
+		}
+		i++
+	}
+	latex := texGroup(mathToLaTeX(children[0])) + post.String()
+	if pre.Len() > 0 {
+		latex = `{}` + pre.String() + latex
+	}
+	return latex
+}
+
+func mathFenced(n *html.Node, children []*html.Node) string {
+	open, ok := getAttr(n, "open")
+	if !ok {
+		open = "("
+	}
+	closing, ok := getAttr(n, "close")
+	if !ok {
+		closing = ")"
+	}
+	separators, ok := getAttr(n, "separators")
+	if !ok {
+		separators = ","
+	}
+	separatorList := []rune(strings.Join(strings.Fields(separators), ""))
+	var b strings.Builder
+	for i, c := range children {
+		if i > 0 && len(separatorList) > 0 {
+			b.WriteString(string(separatorList[min(i-1, len(separatorList)-1)]))
+		}
+		b.WriteString(mathToLaTeX(c))
+	}
+	return `\left` + texDelimiter(open) + b.String() + `\right` + texDelimiter(closing)
+}
+
+func texDelimiter(delimiter string) string {
+	switch delimiter {
+	case "":
+		return "."
+	case "{", "}":
+		return `\` + delimiter
+	case "⟨":
+		return `\langle`
+	case "⟩":
+		return `\rangle`
+	}
+	return delimiter
+}
+
+// texGroup braces LaTeX longer than a single character or command, so scripts apply to all of it
+func texGroup(latex string) string {
+	if utf8.RuneCountInString(latex) <= 1 || (strings.HasPrefix(latex, `\`) && texCommandEnd.MatchString(latex) && !strings.ContainsAny(latex[1:], `\{`)) {
+		return latex
+	}
+	return `{` + latex + `}`
+}
+
+var texEscaper = strings.NewReplacer(
+	`\`, `\backslash{}`, `{`, `\{`, `}`, `\}`, `#`, `\#`, `$`, `\$`, `%`, `\%`, `&`, `\&`,
+	`_`, `\_`, `^`, `\^{}`, `~`, `\~{}`,
+)
+
+func texEscape(text string) string {
+	return texEscaper.Replace(text)
+}
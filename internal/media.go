@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// MediaResource is an audio or video source a chapter plays, in document order
+type MediaResource struct {
+	Element   string        // audio or video
+	Href      string        // full path inside the archive, the URL of remote resources
+	MediaType string        // of the manifest item, the type attribute for remote resources
+	Duration  time.Duration // media:duration of the manifest item, zero when not declared
+	Poster    string        // full path of the poster image of a video, empty when there is none
+}
+
+// elements whose src, and poster for video, point at media files
+var mediaSourceElements = setOf("audio", "video", "source", "track")
+
+// maxEmbeddedMediaSize caps the files embedded as data URIs, larger media keep their path inside
+// the archive and are read through Content.Media
+var maxEmbeddedMediaSize uint64 = 8 << 20
+
+// getMediaDurations reads the media:duration metadata refining manifest items, keyed by item id
+func getMediaDurations(metas []Meta) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, meta := range metas {
+		if meta.Property != "media:duration" || meta.Refines == "" {
+			continue
+		}
+		if duration, ok := parseClockValue(meta.Text); ok {
+			durations[strings.TrimPrefix(meta.Refines, "#")] = duration
+		}
+	}
+	return durations
+}
+
+// parseClockValue parses the SMIL clock values media:duration uses: full and partial clocks
+// ("0:01:30.5", "01:30") and timecounts with an optional h, min, s or ms metric ("90s", "1.5min")
+func parseClockValue(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ":") {
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return 0, false
+		}
+		var seconds float64
+		for _, part := range parts {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, false
+			}
+			seconds = seconds*60 + n
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	unit := time.Second
+	for _, metric := range []struct {
+		suffix string
+		unit   time.Duration
+	}{{"ms", time.Millisecond}, {"min", time.Minute}, {"h", time.Hour}, {"s", time.Second}} {
+		if strings.HasSuffix(value, metric.suffix) {
+			value, unit = strings.TrimSuffix(value, metric.suffix), metric.unit
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n * float64(unit)), true
+}
+
+// getChapterMedia lists the audio and video sources of doc, resolved against the content file.
+// Sources outside the manifest are left out, the same as they are left out of the chapter HTML
+func getChapterMedia(doc *html.Node, contentFilePath string, manifestHrefMap map[string]Item, durations map[string]time.Duration) []MediaResource {
+	var media []MediaResource
+	add := func(element string, n *html.Node, poster string) {
+		src, ok := getAttr(n, "src")
+		if !ok || strings.TrimSpace(src) == "" {
+			return
+		}
+		mediaType, _ := getAttr(n, "type")
+		resource := MediaResource{Element: element, Href: strings.TrimSpace(src), MediaType: mediaType, Poster: poster}
+		if mediaPath, ok := resolveResourcePath(contentFilePath, src); ok {
+			item, ok := manifestHrefMap[mediaPath]
+			if !ok {
+				return
+			}
+			resource.Href, resource.MediaType, resource.Duration = mediaPath, item.MediaType, durations[item.Id]
+		} else if !isRemoteURL(resource.Href) {
+			return
+		}
+		media = append(media, resource)
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "audio" || n.Data == "video") {
+			poster := ""
+			if ref, ok := getAttr(n, "poster"); ok && n.Data == "video" {
+				if posterPath, ok := resolveResourcePath(contentFilePath, ref); ok {
+					if _, ok := manifestHrefMap[posterPath]; ok {
+						poster = posterPath
+					}
+				}
+			}
+			add(n.Data, n, poster)
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && c.Data == "source" {
+					add(n.Data, c, poster)
+				}
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return media
+}
+
+// embedMediaSources embeds the src and poster files of a media element of the archive, files over
+// maxEmbeddedMediaSize are referenced by their full path instead. References that can't be embedded
+// are removed, remote ones are left to the sanitizer. It reports whether the element still has a source
+func embedMediaSources(n *html.Node, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item) bool {
+	var attrs []html.Attribute
+	hasSource := false
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && (attr.Key == "src" || attr.Key == "poster") {
+			if _, local := resolveResourcePath(contentFilePath, attr.Val); local {
+				ref, ok := embedMediaFile(r, contentFilePath, attr.Val, manifestHrefMap)
+				if !ok {
+					continue
+				}
+				attr.Val = ref
+			} else if !isRemoteURL(attr.Val) {
+				continue
+			}
+			hasSource = hasSource || attr.Key == "src"
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+	return hasSource
+}
+
+// embedMediaFile returns the data URI of a media file of the archive, or its full path when it is
+// over maxEmbeddedMediaSize
+func embedMediaFile(r *zip.ReadCloser, contentFilePath string, ref string, manifestHrefMap map[string]Item) (string, bool) {
+	mediaPath, ok := resolveResourcePath(contentFilePath, ref)
+	if !ok {
+		return "", false
+	}
+	if _, ok := manifestHrefMap[mediaPath]; !ok {
+		return "", false
+	}
+	if size, ok := getZipFileSize(r, mediaPath); ok && size > maxEmbeddedMediaSize {
+		return mediaPath, true
+	}
+	return embedResource(r, contentFilePath, ref, manifestHrefMap)
+}
+
+// getZipFileSize returns the uncompressed size of an archive file without reading it
+func getZipFileSize(r *zip.ReadCloser, filePath string) (uint64, bool) {
+	cleanPath := filepath.Clean(filePath)
+	for _, f := range r.File {
+		if f.Name == cleanPath {
+			return f.UncompressedSize64, true
+		}
+	}
+	return 0, false
+}
+
+// isRemoteURL reports an absolute http or https URL, EPUB allows remote audio and video
+func isRemoteURL(ref string) bool {
+	lower := strings.ToLower(strings.TrimSpace(ref))
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
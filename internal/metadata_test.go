@@ -0,0 +1,278 @@
+package parser
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_title_refinements(t *testing.T) {
+	titles := []DefaultAttributes{
+		{Text: "A Collection of Tales", Id: "t3"},
+		{Text: "Stories", Id: "t1"},
+		{Text: "Short Stories for Learners", Id: "t2"},
+	}
+	metaMap := *getMetaMap([]Meta{
+		{Refines: "#t1", Property: "title-type", Text: "main"},
+		{Refines: "#t1", Property: "file-as", Text: "Stories, Spanish"},
+		{Refines: "#t2", Property: "title-type", Text: "subtitle"},
+		{Refines: "#t3", Property: "title-type", Text: "collection"},
+	})
+
+	main, subtitle, sortTitle := getTitleParts(getTitleDetails3(titles, metaMap), "")
+	if main.Title != "Stories" || subtitle != "Short Stories for Learners" || sortTitle != "Stories, Spanish" {
+		t.Logf("unexpected titles %q %q %q", main.Title, subtitle, sortTitle)
+		t.Fail()
+	}
+}
+
+func Test_derived_sort_title(t *testing.T) {
+	cases := []struct {
+		title        Title
+		bookLanguage string
+		expected     string
+	}{
+		{Title{Title: "The Hobbit", Language: "en-GB"}, "", "Hobbit, The"},
+		{Title{Title: "An Essay", Language: "en"}, "fr", "Essay, An"},
+		{Title{Title: "The Hobbit"}, "en", "Hobbit, The"},
+		{Title{Title: "The Hobbit"}, "", ""},
+		{Title{Title: "A Cidade e as Serras"}, "pt", ""},
+		{Title{Title: "A Cidade e as Serras", Language: "pt"}, "en", ""},
+		{Title{Title: "Hobbit, The", Language: "en", FileAs: "Hobbit"}, "en", "Hobbit"},
+	}
+	for _, c := range cases {
+		if _, _, sortTitle := getTitleParts(&[]Title{c.title}, c.bookLanguage); sortTitle != c.expected {
+			t.Logf("expected sort title %q for %+v but got %q", c.expected, c.title, sortTitle)
+			t.Fail()
+		}
+	}
+}
+
+func Test_creator_attributes(t *testing.T) {
+	opf := OPFPackage{}
+	err := xml.Unmarshal([]byte(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+<dc:creator id="author">Jane Doe</dc:creator>
+<meta refines="#author" property="role" scheme="marc:relators">aut</meta>
+<meta refines="#author" property="file-as">Doe, Jane</meta>
+<dc:contributor opf:role="trl" opf:file-as="Roe, Rick">Rick Roe</dc:contributor>
+<dc:contributor id="ill" opf:role="aut">Ann Artist</dc:contributor>
+<meta refines="#ill" property="role">ill</meta>
+</metadata></package>`), &opf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaMap := *getMetaMap(*opf.Metadata.Meta)
+	creators := append(*getCreators3(*opf.Metadata.Creator, metaMap), *getCreators3(*opf.Metadata.Contributor, metaMap)...)
+	expected := []struct{ name, rawRole, fileAs string }{
+		{"Jane Doe", "aut", "Doe, Jane"},
+		{"Rick Roe", "trl", "Roe, Rick"},
+		// the refinement wins over the attribute
+		{"Ann Artist", "ill", ""},
+	}
+	if len(creators) != len(expected) {
+		t.Fatalf("expected %d creators but got %+v", len(expected), creators)
+	}
+	for i, creator := range creators {
+		if creator.Name != expected[i].name || creator.RawRole != expected[i].rawRole || creator.FileAs != expected[i].fileAs || creator.Role != Relator[expected[i].rawRole] {
+			t.Logf("expected %+v but got %+v", expected[i], creator)
+			t.Fail()
+		}
+	}
+}
+
+func Test_series(t *testing.T) {
+	series := getSeries([]Meta{
+		{Property: "belongs-to-collection", Id: "c01", Text: "Graded Readers"},
+		{Refines: "#c01", Property: "collection-type", Text: "series"},
+		{Refines: "#c01", Property: "group-position", Text: "2"},
+		{Name: "calibre:series", Content: "Penguin Readers"},
+		{Name: "calibre:series_index", Content: "3.5"},
+	})
+	if len(series) != 2 {
+		t.Fatalf("series expected 2 but is %d", len(series))
+	}
+	if series[0].Name != "Graded Readers" || series[0].Type != CollectionTypeSeries || series[0].Position != 2 {
+		t.Logf("unexpected collection %+v", series[0])
+		t.Fail()
+	}
+	if series[1].Name != "Penguin Readers" || series[1].Position != 3.5 {
+		t.Logf("unexpected calibre series %+v", series[1])
+		t.Fail()
+	}
+}
+
+func Test_classify_identifier(t *testing.T) {
+	cases := []struct {
+		value, scheme, wantType, wantNormalized string
+		wantValid                               bool
+	}{
+		{"urn:isbn:978-0-14-143947-1", "", IdentifierISBN13, "9780141439471", true},
+		{"0-306-40615-2", "ISBN", IdentifierISBN10, "9780306406157", true},
+		{"0-306-40615-3", "ISBN", IdentifierISBN10, "9780306406157", false},
+		{"9780306406157", "calibre", IdentifierISBN13, "9780306406157", true},
+		{"urn:uuid:8F1E2B2C-1D9C-4B1A-9A3F-6D4E5F6A7B8C", "", IdentifierUUID, "8f1e2b2c-1d9c-4b1a-9a3f-6d4e5f6a7b8c", true},
+		{"doi:10.1000/182", "", IdentifierDOI, "10.1000/182", true},
+		{"B00ABCDEFG", "", IdentifierASIN, "B00ABCDEFG", true},
+		{"http://www.gutenberg.org/43", "URI", IdentifierURL, "http://www.gutenberg.org/43", true},
+	}
+	for _, c := range cases {
+		got := classifyIdentifier(c.value, c.scheme)
+		if got.Type != c.wantType || got.Normalized != c.wantNormalized || got.Valid != c.wantValid {
+			t.Logf("%q: got %s %s %v", c.value, got.Type, got.Normalized, got.Valid)
+			t.Fail()
+		}
+	}
+}
+
+func Test_normalize_language(t *testing.T) {
+	cases := map[string]string{
+		"en":      "en",
+		"eng":     "en",
+		"en_US":   "en-US",
+		"English": "en",
+		"Español": "es",
+		"fre":     "fr",
+	}
+	for raw, expected := range cases {
+		tag, ok := normalizeLanguage(raw)
+		if !ok || tag.String() != expected {
+			t.Logf("%q expected %q but is %q", raw, expected, tag.String())
+			t.Fail()
+		}
+	}
+
+	tag, _ := normalizeLanguage("pt_BR")
+	resolver := TableLanguageResolver{IDs: map[string]int{"pt": 6}}
+	if id, ok := resolver.ResolveLanguageID(tag); !ok || id != 6 {
+		t.Logf("pt-BR expected to resolve to the pt id but is %d", id)
+		t.Fail()
+	}
+}
+
+func Test_subjects_genre(t *testing.T) {
+	subjects := getSubjects([]dcElement{
+		{Text: "Readers -- Spanish"},
+		{Text: "Horror", Id: "s1"},
+		{Text: "FIC028000"},
+	}, *getMetaMap([]Meta{
+		{Refines: "#s1", Property: "authority", Text: "thema"},
+		{Refines: "#s1", Property: "term", Text: "FK"},
+	}))
+
+	if subjects[1].Authority != AuthorityThema || subjects[1].Term != "FK" {
+		t.Logf("unexpected refined subject %+v", subjects[1])
+		t.Fail()
+	}
+	if subjects[2].Authority != AuthorityBISAC || subjects[2].Term != "FIC028000" {
+		t.Logf("unexpected bisac subject %+v", subjects[2])
+		t.Fail()
+	}
+
+	// coded subjects win over free text
+	genre, ok := DefaultGenreMapper.MapGenre(subjects)
+	if !ok || genre.Name != GenreHorror || genre.ID != DefaultGenreMapper.Genres[GenreHorror] {
+		t.Logf("genre expected %s but is %+v", GenreHorror, genre)
+		t.Fail()
+	}
+}
+
+func Test_subject_keywords(t *testing.T) {
+	cases := map[string]string{
+		"Readers -- Spanish":                GenreLanguage,
+		"Spanish language -- Grammar":       GenreLanguage,
+		"English drama -- 17th century":     GenreDrama,
+		"Thrillers (Fiction)":               GenreThriller,
+		"Children's stories":                GenreChildren,
+		"Authors, English -- Autobiography": GenreBiography,
+	}
+	for value, expected := range cases {
+		subjects := getSubjects([]dcElement{{Text: value}}, map[string]map[string]Meta{})
+		if genre, ok := DefaultGenreMapper.MapGenre(subjects); !ok || genre.Name != expected {
+			t.Logf("%s expected %s but is %+v", value, expected, genre)
+			t.Fail()
+		}
+	}
+
+	// keywords are whole words, not parts of unrelated ones
+	for _, value := range []string{"Displays", "Programming languages", "Proofreaders", "Fictional universes"} {
+		subjects := getSubjects([]dcElement{{Text: value}}, map[string]map[string]Meta{})
+		if genre, ok := DefaultGenreMapper.MapGenre(subjects); ok {
+			t.Logf("expected no genre for %s but got %+v", value, genre)
+			t.Fail()
+		}
+	}
+}
+
+func Test_bisac_headings_genre(t *testing.T) {
+	cases := map[string]string{
+		"FICTION / Horror":                       GenreHorror,
+		"FICTION / Science Fiction / General":    GenreScienceFiction,
+		"FICTION / Literary":                     GenreFiction,
+		"PHILOSOPHY / Ethics & Moral Philosophy": GenreNonFiction,
+		"Essays -- Non-fiction":                  GenreNonFiction,
+	}
+	for value, expected := range cases {
+		subjects := getSubjects([]dcElement{{Text: value}}, map[string]map[string]Meta{})
+		genre, ok := DefaultGenreMapper.MapGenre(subjects)
+		if !ok || genre.Name != expected {
+			t.Logf("%s expected %s but is %+v", value, expected, genre)
+			t.Fail()
+		}
+	}
+
+	// a heading that isn't in the table leaves the decision to the keywords
+	subjects := getSubjects([]dcElement{{Text: "OCCULT / Ghost stories"}}, map[string]map[string]Meta{})
+	if subjects[0].Authority != AuthorityBISAC || subjects[0].Term != "" {
+		t.Logf("unexpected heading subject %+v", subjects[0])
+		t.Fail()
+	}
+	if genre, _ := DefaultGenreMapper.MapGenre(subjects); genre.Name != GenreHorror {
+		t.Logf("expected the ghost stories keyword to give %s but got %+v", GenreHorror, genre)
+		t.Fail()
+	}
+}
+
+func Test_select_rendition(t *testing.T) {
+	container := Container{}
+	err := xml.Unmarshal([]byte(`<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:rendition="http://www.idpf.org/2013/rendition" version="1.0">
+  <rootfiles>
+    <rootfile full-path="EPUB/reflow.opf" media-type="application/oebps-package+xml"/>
+    <rootfile full-path="EPUB/fixed.opf" media-type="application/oebps-package+xml" rendition:layout="pre-paginated" rendition:media="(min-width: 1024px)"/>
+    <rootfile full-path="book.pdf" media-type="application/pdf"/>
+    <rootfile full-path="EPUB/fr.opf" media-type="application/oebps-package+xml" rendition:language="fr-CA" rendition:label="Français"/>
+  </rootfiles>
+</container>`), &container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selections := []struct {
+		selector RenditionSelector
+		expected string
+		found    bool
+	}{
+		{RenditionSelector{}, "EPUB/reflow.opf", true},
+		{RenditionSelector{Layout: LayoutPrePaginated}, "EPUB/fixed.opf", true},
+		{RenditionSelector{Language: "fr"}, "EPUB/fr.opf", true},
+		{RenditionSelector{Index: 2}, "EPUB/fr.opf", true},
+		{RenditionSelector{Language: "de"}, "EPUB/reflow.opf", false},
+	}
+	for _, selection := range selections {
+		renditions, diagnostics, err := chooseRootfile(&container, selection.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if container.Rootfile.Path != selection.expected || (len(diagnostics) == 0) != selection.found {
+			t.Logf("%+v expected %s but selected %s with %v", selection.selector, selection.expected, container.Rootfile.Path, diagnostics)
+			t.Fail()
+		}
+		if len(renditions) != 3 {
+			t.Fatalf("expected the 3 package renditions but got %v", renditions)
+		}
+	}
+	renditions, _, _ := chooseRootfile(&container, RenditionSelector{})
+	if renditions[1].Media != "(min-width: 1024px)" || renditions[2].Label != "Français" || !renditions[0].Selected {
+		t.Logf("unexpected rendition attributes %+v", renditions)
+		t.Fail()
+	}
+}
@@ -1,17 +1,41 @@
 package parser
 
 type ResultMetadata struct {
-	MainId      string
-	Title       string
-	Identifier  string
-	Language    string
-	Creator     string
-	Contributor string
-	Publisher   string
-	Subject     string
-	Description string
-	Date        string
-	Cover       Cover
+	MainId            string
+	Title             string
+	Subtitle          string
+	SortTitle         string
+	Titles            []Title
+	Identifier        string
+	Identifiers       []BookIdentifier
+	Isbn              string             // first valid ISBN, normalized to ISBN-13
+	Language          string             // BCP 47 form of the first dc:language
+	Languages         []string           // every declared language as BCP 47
+	LanguageID        int                // id from the LanguageResolver, 0 when unresolved
+	LanguageDetection *LanguageDetection // nil when detection is skipped
+	Creator           string
+	Contributor       string
+	Publisher         string
+	Subject           string
+	Subjects          []Subject
+	Genre             string // from the GenreMapper, empty when no subject maps to a genre
+	GenreID           int    // application id of Genre, for DatabaseBook.SubjectID
+	Description       string
+	Date              string
+	Dates             []BookDate
+	PublicationDate   *BookDate // nil when the book doesn't declare one, same for creation and modification
+	CreationDate      *BookDate
+	ModificationDate  *BookDate
+	PublicationYear   *int
+	Cover             Cover
+	Accessibility     Accessibility
+	Rendition         Rendition
+	PageProgression   string // ltr or rtl from the spine, empty when the reading system decides
+	ImageOnly         bool   // every spine item is a single image
+	People            []Person
+	Series            []Series
+	DublinCore        DublinCore
+	Modified          string // dcterms:modified
 }
 
 type Cover struct {
@@ -21,13 +45,41 @@ type Cover struct {
 }
 
 type Content struct {
-	Html  string
-	Title string
+	Id                  string // manifest id of the rendered document
+	Href                string // full path of the rendered document inside the archive
+	Html                string
+	Text                string // plain text, one line per block element
+	Title               string
+	Language            string         // xml:lang or lang of the document, the book language when it declares none
+	LanguageSpans       []LanguageSpan // runs of Text by effective language
+	AccessibilityIssues []AccessibilityIssue
+	Layout              string    // reflowable or pre-paginated after the spine overrides
+	PageSpread          string    // left, right or center, empty when not declared
+	Viewport            *Viewport // page size of pre-paginated documents
+	Media               []MediaResource
 }
 
+type Diagnostic struct {
+	Code    string
+	Message string
+}
+
+// diagnostic codes
+const (
+	DiagnosticLanguageMismatch = "language-mismatch"
+)
+
 type ParsedBookResult struct {
-	Metadata *ResultMetadata
-	Texts    []Content
+	Metadata    *ResultMetadata
+	Manifest    []ManifestItem
+	Texts       []Content
+	Pages       []PageImage // image-only books in ImagePages mode, Texts is empty then
+	Renditions  []PackageRendition
+	Diagnostics []Diagnostic
+	// Stylesheet holds the book's sanitized stylesheets in PreserveStyles mode, scoped to
+	// chapters placed in an element with the StyleScope class
+	Stylesheet string
+	StyleScope string
 }
 
 type DatabaseBook struct {
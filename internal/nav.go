@@ -7,11 +7,11 @@ import (
 	"golang.org/x/net/html"
 )
 
-// ParseNavDoc parses the EPUB 3 Navigation Document (NAV.xhtml) or toc.xhtml
-// Returns a map of {Cleaned href (without hash): Link text content}
-func ParseNavDoc(fBytes []byte, rootDir string) (map[string]string, error) {
+// ParseNavDoc parses the EPUB 3 Navigation Document (NAV.xhtml) or toc.xhtml, mediaType is the
+// one of its manifest item. Returns a map of {Cleaned href (without hash): Link text content}
+func ParseNavDoc(fBytes []byte, rootDir string, mediaType string) (map[string]string, error) {
 	tocMap := make(map[string]string)
-	doc, err := html.Parse(strings.NewReader(string(fBytes)))
+	doc, err := parseContentDocument(fBytes, mediaType)
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +29,7 @@ type XMLContent struct {
 // Returns a map of {Full Content Path: Title}
 func ParseNcx(fBytes []byte, rootDir string) (map[string]string, error) {
 	var ncx NCX
-	err := xml.Unmarshal(fBytes, &ncx)
+	err := newXMLDecoder(fBytes).Decode(&ncx)
 	if err != nil {
 		return nil, err
 	}
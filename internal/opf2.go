@@ -2,6 +2,7 @@ package parser
 
 import (
 	"encoding/xml"
+	"strings"
 )
 
 func getManifest(metaData Manifest) Manifest {
@@ -9,12 +10,15 @@ func getManifest(metaData Manifest) Manifest {
 
 	for i, m := range *metaData.Item {
 		refs[i] = Item{
-			Id:        m.Id,
-			Href:      m.Href,
-			MediaType: m.MediaType,
-			// Fallback:     m.Fallback,
-			// MediaOverlay: "",
-			// Properties:   "",
+			Id:                m.Id,
+			Href:              m.Href,
+			MediaType:         m.MediaType,
+			Properties:        m.Properties,
+			Fallback:          m.Fallback,
+			FallbackStyle:     m.FallbackStyle,
+			MediaOverlay:      m.MediaOverlay,
+			RequiredModules:   m.RequiredModules,
+			RequiredNamespace: m.RequiredNamespace,
 		}
 	}
 	return Manifest{
@@ -28,12 +32,16 @@ func getSpine(metaData Spine) Spine {
 
 	for i, ir := range metaData.Itemrefs {
 		refs[i] = Itemref{
-			Idref: ir.Idref,
+			Idref:      ir.Idref,
+			Id:         ir.Id,
+			Linear:     ir.Linear,
+			Properties: ir.Properties,
 		}
 	}
 	return Spine{
-		Toc:      metaData.Toc,
-		Itemrefs: refs,
+		Toc:                      metaData.Toc,
+		PageProgressionDirection: metaData.PageProgressionDirection,
+		Itemrefs:                 refs,
 	}
 }
 
@@ -57,6 +65,31 @@ func getTitles(metaData []DefaultAttributes) *[]DefaultAttributes {
 	return &titles
 }
 
+// getTitleDetails follows the EPUB 2 convention of a main title followed by subtitles,
+// calibre stores the sort title in its own meta
+func getTitleDetails(metaData []DefaultAttributes, metas []Meta) *[]Title {
+	titles := make([]Title, len(metaData))
+	for i, title := range metaData {
+		titleType := TitleTypeMain
+		if i > 0 {
+			titleType = TitleTypeSubtitle
+		}
+		titles[i] = Title{
+			Title:    strings.TrimSpace(title.Text),
+			Language: title.Lang,
+			Type:     titleType,
+		}
+	}
+	if len(titles) > 0 {
+		for _, meta := range metas {
+			if meta.Name == "calibre:title_sort" && meta.Content != "" {
+				titles[0].FileAs = strings.TrimSpace(meta.Content)
+			}
+		}
+	}
+	return &titles
+}
+
 func getLanguages(metaData []ID) *[]ID {
 	languages := make([]ID, len(metaData))
 	for i, language := range metaData {
@@ -73,14 +106,16 @@ func getCreators(metaData []Creator) *[]Creator {
 	if metaData != nil {
 		creators := make([]Creator, len(metaData))
 		for i, creator := range metaData {
-			role, ok := Relator[creator.Role]
-			if !ok && creator.Role != "" {
+			rawRole := strings.TrimSpace(creator.Role)
+			role, ok := Relator[rawRole]
+			if !ok && rawRole != "" {
 				role = "unknown"
 			}
 			creators[i] = Creator{
-				Name:     creator.Text,
-				FileAs:   creator.FileAs,
-				RawRole:  creator.Role,
+				Id:       creator.Id,
+				Name:     strings.TrimSpace(creator.Text),
+				FileAs:   strings.TrimSpace(creator.FileAs),
+				RawRole:  rawRole,
 				Language: creator.Lang,
 				Role:     role,
 			}
@@ -115,25 +150,20 @@ func getDate(metaData []Date) *[]Date {
 	return nil
 }
 
-func ParseOpf(opfFilePath string, book *Book) error {
-	opf := OPFPackage{}
-	err := book.ReadXML(opfFilePath, &opf)
-	if err != nil {
-		return err
-	}
-
+// ParseOpf reads an EPUB 2 package document decoded by OpenBookWithOptions into book
+func ParseOpf(opf *OPFPackage, book *Book) error {
 	identifiers := make([]ID, len(*opf.Metadata.Identifier))
 	for i, identifier := range *opf.Metadata.Identifier {
 		identifiers[i] = ID{
-			Id:     identifier.Text,
+			Id:     strings.TrimSpace(identifier.Text),
 			Scheme: identifier.Scheme,
 		}
-		// if identifier.Id == opf.ID {
-		// 	book.Metadata.MainId = Identifier{
-		// 		Id:     identifier.Text,
-		// 		Scheme: identifier.Scheme,
-		// 	}
-		// }
+		if identifier.Id != "" && identifier.Id == opf.UniqueIdentifier {
+			book.Metadata.MainId = &Identifier{
+				Id:     identifiers[i].Id,
+				Scheme: identifier.Scheme,
+			}
+		}
 	}
 
 	book.Metadata.Identifier = &identifiers
@@ -148,6 +178,11 @@ func ParseOpf(opfFilePath string, book *Book) error {
 
 	if opf.Metadata.Title != nil {
 		book.Metadata.Title = getTitles(*opf.Metadata.Title)
+		if opf.Metadata.Meta != nil {
+			book.Metadata.Titles = getTitleDetails(*opf.Metadata.Title, *opf.Metadata.Meta)
+		} else {
+			book.Metadata.Titles = getTitleDetails(*opf.Metadata.Title, nil)
+		}
 	}
 	if opf.Metadata != nil {
 		book.Metadata.Language = getLanguages(*opf.Metadata.Language)
@@ -180,7 +215,7 @@ func ParseOpf(opfFilePath string, book *Book) error {
 		book.Metadata.CoverId = getCoverId(*opf.Metadata.Meta)
 	}
 
-	return err
+	return nil
 }
 
 type OPFPackage struct {
@@ -203,7 +238,9 @@ type Identifier struct {
 
 type Metadata struct {
 	Title       *[]DefaultAttributes `xml:"title,dc:title"`
+	Titles      *[]Title             `xml:"-"`
 	CoverId     string               `xml:"coverId,omitempty"`
+	MainId      *Identifier          `xml:"-"`
 	Identifier  *[]ID                `xml:"identifier,dc:identifier"`
 	Language    *[]ID                `xml:"language,dc:language"`
 	Creator     *[]Creator           `xml:"creator,dc:creator,omitempty"`
@@ -219,25 +256,111 @@ type Metadata struct {
 	Coverage    *[]DefaultAttributes `xml:"coverage,omitempty"`
 	Rights      *[]DefaultAttributes `xml:"rights,omitempty"`
 	Meta        *[]Meta              `xml:"meta,omitempty"`
+	dc          dcMetadata           // every dc element with all its attributes, see UnmarshalXML
+}
+
+// UnmarshalXML decodes the metadata element once into the Dublin Core elements ParseDublinCore
+// reads and derives the typed fields of ParseOpf and ParseOpf3 from them
+func (m *Metadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var dc dcMetadata
+	if err := d.DecodeElement(&dc, &start); err != nil {
+		return err
+	}
+	*m = Metadata{
+		Title:       toDefaultAttributes(dc.Title),
+		Identifier:  toIDs(dc.Identifier),
+		Language:    toIDs(dc.Language),
+		Creator:     toCreators(dc.Creator),
+		Contributor: toCreators(dc.Contributor),
+		Publisher:   toDefaultAttributes(dc.Publisher),
+		Subject:     toDefaultAttributes(dc.Subject),
+		Description: toDefaultAttributes(dc.Description),
+		Date:        toDates(dc.Date),
+		Type:        toIDs(dc.Type),
+		Format:      toIDs(dc.Format),
+		Source:      toDefaultAttributes(dc.Source),
+		Relation:    toDefaultAttributes(dc.Relation),
+		Coverage:    toDefaultAttributes(dc.Coverage),
+		Rights:      toDefaultAttributes(dc.Rights),
+		dc:          dc,
+	}
+	if len(dc.Meta) > 0 {
+		m.Meta = &dc.Meta
+	}
+	return nil
+}
+
+// the typed fields are nil when the package has no such element, the same as decoding them directly
+func toDefaultAttributes(elements []dcElement) *[]DefaultAttributes {
+	if len(elements) == 0 {
+		return nil
+	}
+	values := make([]DefaultAttributes, len(elements))
+	for i, e := range elements {
+		values[i] = DefaultAttributes{Text: e.Text, Id: e.Id, Lang: e.Lang}
+	}
+	return &values
+}
+
+func toIDs(elements []dcElement) *[]ID {
+	if len(elements) == 0 {
+		return nil
+	}
+	values := make([]ID, len(elements))
+	for i, e := range elements {
+		values[i] = ID{Text: e.Text, Id: e.Id}
+		if e.Scheme != "" {
+			scheme := e.Scheme
+			values[i].Scheme = &scheme
+		}
+	}
+	return &values
+}
+
+func toCreators(elements []dcElement) *[]Creator {
+	if len(elements) == 0 {
+		return nil
+	}
+	values := make([]Creator, len(elements))
+	for i, e := range elements {
+		values[i] = Creator{Text: e.Text, FileAs: e.FileAs, Id: e.Id, Lang: e.Lang, Role: e.Role}
+	}
+	return &values
+}
+
+func toDates(elements []dcElement) *[]Date {
+	if len(elements) == 0 {
+		return nil
+	}
+	values := make([]Date, len(elements))
+	for i, e := range elements {
+		values[i] = Date{Text: e.Text, Event: e.Event, Id: e.Id}
+	}
+	return &values
 }
 
 type Spine struct {
-	Toc      string    `xml:"toc,attr"`
-	Itemrefs []Itemref `xml:"itemref"`
+	Toc                      string    `xml:"toc,attr"`
+	PageProgressionDirection string    `xml:"page-progression-direction,attr,omitempty"`
+	Itemrefs                 []Itemref `xml:"itemref"`
 }
 
 type Itemref struct {
-	Idref string `xml:"idref,attr"`
+	Idref      string `xml:"idref,attr"`
+	Id         string `xml:"id,attr,omitempty"`
+	Linear     string `xml:"linear,attr,omitempty"`
+	Properties string `xml:"properties,attr,omitempty"`
 }
 type Creator struct {
-	Text     string `xml:",chardata"`
-	FileAs   string `xml:"file-as,attr,omitempty"`
-	Id       string `xml:"id,attr,omitempty"`
-	Lang     string `xml:"lang,attr,omitempty"`
-	Role     string `xml:"role,attr,omitempty"`
-	Name     string
-	Language string
-	RawRole  string
+	Text       string `xml:",chardata"`
+	FileAs     string `xml:"file-as,attr,omitempty"`
+	Id         string `xml:"id,attr,omitempty"`
+	Lang       string `xml:"lang,attr,omitempty"`
+	Role       string `xml:"role,attr,omitempty"`
+	Name       string
+	Language   string
+	RawRole    string
+	DisplaySeq int
 }
 
 type DefaultAttributes struct {
@@ -282,15 +405,17 @@ type Item struct {
 	Properties        string  `xml:"properties,attr"`
 	Fallback          *string `xml:"fallback,attr,omitempty"`
 	FallbackStyle     *string `xml:"fallback-style,attr,omitempty"`
+	MediaOverlay      *string `xml:"media-overlay,attr,omitempty"`
 	RequiredModules   *string `xml:"required-modules,attr,omitempty"`
 	RequiredNamespace *string `xml:"required-namespace,attr,omitempty"`
 }
 
 type Title struct {
-	Title    string
-	Language string
-	Type     string
-	FileAs   string
+	Title      string
+	Language   string
+	Type       string // title-type refinement, e.g. "main" or "subtitle"
+	FileAs     string
+	DisplaySeq int
 }
 
 var Relator = map[string]string{
@@ -2,28 +2,39 @@ package parser
 
 import (
 	"archive/zip"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type Container struct {
-	Rootfile Rootfile `xml:"rootfiles>rootfile"`
+	Rootfiles []Rootfile `xml:"rootfiles>rootfile"`
+	Rootfile  Rootfile   `xml:"-"` // the rendition being parsed, see selectRootfile
 }
 
+// Rootfile is one <rootfile>, the rendition attributes are only set in multiple-rendition publications
 type Rootfile struct {
-	Path string `xml:"full-path,attr"`
-	Type string `xml:"media-type,attr"`
+	Path       string `xml:"full-path,attr"`
+	Type       string `xml:"media-type,attr"`
+	Media      string `xml:"http://www.idpf.org/2013/rendition media,attr"`
+	Layout     string `xml:"http://www.idpf.org/2013/rendition layout,attr"`
+	Language   string `xml:"http://www.idpf.org/2013/rendition language,attr"`
+	AccessMode string `xml:"http://www.idpf.org/2013/rendition accessMode,attr"`
+	Label      string `xml:"http://www.idpf.org/2013/rendition label,attr"`
 }
 
 type Book struct {
-	Metadata  Metadata
-	Manifest  Manifest
-	Container Container
-	Spine     Spine
-	ZipReader *zip.ReadCloser
+	Metadata   Metadata
+	DublinCore DublinCore
+	Modified   string
+	Manifest   Manifest
+	Container  Container
+	Spine      Spine
+	ZipReader  *zip.ReadCloser
+	dcMetadata dcMetadata
 }
 
 func (book *Book) ReadXML(fileName string, targetStruct interface{}) error {
@@ -32,8 +43,11 @@ func (book *Book) ReadXML(fileName string, targetStruct interface{}) error {
 		return err
 	}
 	defer reader.Close()
-	dec := xml.NewDecoder(reader)
-	return dec.Decode(targetStruct)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return newXMLDecoder(data).Decode(targetStruct)
 }
 
 func (book *Book) open(fileName string) (io.ReadCloser, error) {
@@ -48,33 +62,58 @@ func (book *Book) open(fileName string) (io.ReadCloser, error) {
 func getTitles3(metaData []DefaultAttributes, metaMap map[string]map[string]Meta) *[]DefaultAttributes {
 	titles := make([]DefaultAttributes, len(metaData))
 	for i, title := range metaData {
-		// fileAs := getMetadata(metaMap, title.Id, "file-as")
-		// titleType := getMetadata(metaMap, title.Id, "title-type")
 		titles[i] = DefaultAttributes{
 			Text: title.Text,
 			Id:   title.Id,
 			Lang: title.Lang,
-			// Language: title.Lang,
-			// Type:     titleType,
-			// FileAs:   fileAs,
 		}
 	}
 	return &titles
 }
 
-func getCreators3(metaData []DefaultAttributes, metaMap map[string]map[string]Meta) *[]Creator {
+// getTitleDetails3 applies the title-type, file-as and display-seq refinements to every title
+func getTitleDetails3(metaData []DefaultAttributes, metaMap map[string]map[string]Meta) *[]Title {
+	titles := make([]Title, len(metaData))
+	for i, title := range metaData {
+		displaySeq, _ := strconv.Atoi(strings.TrimSpace(getMetadata(metaMap, title.Id, "display-seq")))
+		titles[i] = Title{
+			Title:      strings.TrimSpace(title.Text),
+			Language:   title.Lang,
+			Type:       strings.TrimSpace(getMetadata(metaMap, title.Id, "title-type")),
+			FileAs:     strings.TrimSpace(getMetadata(metaMap, title.Id, "file-as")),
+			DisplaySeq: displaySeq,
+		}
+	}
+	sort.SliceStable(titles, func(i, j int) bool {
+		return lessDisplaySeq(titles[i].DisplaySeq, titles[j].DisplaySeq)
+	})
+	return &titles
+}
+
+// getCreators3 reads role and file-as from the refinements, falling back on the opf:role and
+// opf:file-as attributes of EPUB 2 that EPUB 3 packages still carry
+func getCreators3(metaData []Creator, metaMap map[string]map[string]Meta) *[]Creator {
 	if metaData != nil {
 		creators := make([]Creator, len(metaData))
 		for i, creator := range metaData {
 			fileAs := getMetadata(metaMap, creator.Id, "file-as")
-			rawRole := getMetadata(metaMap, creator.Id, "role")
-			role := getRole(metaMap, creator, rawRole)
+			if strings.TrimSpace(fileAs) == "" {
+				fileAs = creator.FileAs
+			}
+			rawRole := strings.TrimSpace(getMetadata(metaMap, creator.Id, "role"))
+			if rawRole == "" {
+				rawRole = strings.TrimSpace(creator.Role)
+			}
+			role := getRole(metaMap, creator.Id, rawRole)
+			displaySeq, _ := strconv.Atoi(strings.TrimSpace(getMetadata(metaMap, creator.Id, "display-seq")))
 			creators[i] = Creator{
-				Name:     creator.Text,
-				FileAs:   fileAs,
-				RawRole:  rawRole,
-				Language: creator.Lang,
-				Role:     role,
+				Id:         creator.Id,
+				Name:       strings.TrimSpace(creator.Text),
+				FileAs:     strings.TrimSpace(fileAs),
+				RawRole:    rawRole,
+				Language:   creator.Lang,
+				Role:       role,
+				DisplaySeq: displaySeq,
 			}
 		}
 		return &creators
@@ -124,43 +163,41 @@ func getMetaMap(metaData []Meta) *map[string]map[string]Meta {
 	return &metaMap
 }
 
-func getRole(metaMap map[string]map[string]Meta, creator DefaultAttributes, rawRole string) string {
-	scheme := getMetadataSchema(metaMap, creator.Id, "role")
-	role := "unknown"
-	if scheme == "marc:relators" {
-		role, _ = Relator[rawRole]
+func getRole(metaMap map[string]map[string]Meta, id string, rawRole string) string {
+	if rawRole == "" {
+		return ""
 	}
-	return role
+	scheme := getMetadataSchema(metaMap, id, "role")
+	// a role without a scheme is almost always a MARC relator code as well
+	if scheme == "marc:relators" || scheme == "" {
+		if role, ok := Relator[rawRole]; ok {
+			return role
+		}
+	}
+	return "unknown"
 }
 
-func ParseOpf3(opfPath string, book *Book) error {
-	opf := OPFPackage{}
-	err := book.ReadXML(opfPath, &opf)
-	if err != nil {
-		return err
-	}
-	if opf.Metadata.Meta == nil || opf.Metadata.Identifier == nil {
+// ParseOpf3 reads an EPUB 3 package document decoded by OpenBookWithOptions into book
+func ParseOpf3(opf *OPFPackage, book *Book) error {
+	if opf.Metadata == nil || opf.Metadata.Meta == nil || opf.Metadata.Identifier == nil {
 		return errors.New("no metadata")
 	}
 	metaMap := getMetaMap(*opf.Metadata.Meta)
 
 	identifiers := make([]ID, len(*opf.Metadata.Identifier))
 	for i, identifier := range *opf.Metadata.Identifier {
-		parts := strings.Split(identifier.Text, ":")
-		if len(parts) == 2 {
-			scheme := parts[0]
-			id := parts[1]
-			identifiers[i] = ID{
-				Id:     id,
+		value := strings.TrimSpace(identifier.Text)
+		scheme := getIdentifierScheme3(*metaMap, identifier.Id, value)
+		identifiers[i] = ID{
+			Id:     value,
+			Scheme: &scheme,
+		}
+		if identifier.Id != "" && identifier.Id == opf.UniqueIdentifier {
+			book.Metadata.MainId = &Identifier{
+				Id:     value,
 				Scheme: &scheme,
 			}
 		}
-		// if identifier.Id == opf.UniqueIdentifier {
-		// 	book.Metadata. = Identifier{
-		// 		Id:     id,
-		// 		Scheme: &scheme,
-		// 	}
-		// }
 	}
 	book.Metadata.Identifier = &identifiers
 
@@ -173,15 +210,16 @@ func ParseOpf3(opfPath string, book *Book) error {
 	}
 	if opf.Metadata.Title != nil {
 		book.Metadata.Title = getTitles3(*opf.Metadata.Title, *metaMap)
+		book.Metadata.Titles = getTitleDetails3(*opf.Metadata.Title, *metaMap)
 	}
 	if opf.Metadata.Language != nil {
 		book.Metadata.Language = getLanguages(*opf.Metadata.Language)
 	}
 	if opf.Metadata.Creator != nil {
-		book.Metadata.Creator = getCreators(*opf.Metadata.Creator)
+		book.Metadata.Creator = getCreators3(*opf.Metadata.Creator, *metaMap)
 	}
 	if opf.Metadata.Contributor != nil {
-		book.Metadata.Contributor = getCreators(*opf.Metadata.Contributor)
+		book.Metadata.Contributor = getCreators3(*opf.Metadata.Contributor, *metaMap)
 	}
 	if opf.Metadata.Publisher != nil {
 		book.Metadata.Publisher = getDefaultAttributes(*opf.Metadata.Publisher)
@@ -198,7 +236,7 @@ func ParseOpf3(opfPath string, book *Book) error {
 	if metaMap != nil {
 		book.Metadata.CoverId = getCoverId(*opf.Metadata.Meta)
 	}
-	return err
+	return nil
 }
 
 type Link struct {
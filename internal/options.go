@@ -0,0 +1,50 @@
+package parser
+
+// Options changes how a book is parsed, the zero value gives the same result as OpenBook
+type Options struct {
+	// LanguageResolver maps the book language to an application language id,
+	// a TableLanguageResolver over DefaultLanguageIDs is used when nil
+	LanguageResolver LanguageResolver
+	// GenreMapper maps the book subjects to an application genre, DefaultGenreMapper is used when nil
+	GenreMapper GenreMapper
+	// SkipLanguageDetection turns off the statistical language detection over chapter text
+	SkipLanguageDetection bool
+	// FixedLayoutPages renders pre-paginated documents as self-contained fixed-size pages
+	// instead of flattening them into the chapter HTML, content their CSS hides is kept
+	FixedLayoutPages bool
+	// ImagePages returns image-only books (comics, manga) as ParsedBookResult.Pages instead of
+	// HTML chapters, books with any text page are parsed as usual
+	ImagePages bool
+	// Rendition picks the rendition of multiple-rendition publications, the first one by default
+	Rendition RenditionSelector
+	// Sanitizer is the allowlist chapter HTML is rendered through, DefaultSanitizePolicy when nil
+	Sanitizer *SanitizePolicy
+	// PreserveStyles keeps the classes of the chapter HTML and returns the book's stylesheets as
+	// ParsedBookResult.Stylesheet instead of converting their formatting into markup. Content the
+	// stylesheets hide stays in the chapter HTML for them to hide
+	PreserveStyles bool
+	// MathLaTeX replaces MathML in the chapter HTML with LaTeX between \( \) or \[ \] delimiters in
+	// spans of class ClassMath, for clients rendering formulas with KaTeX or MathJax
+	MathLaTeX bool
+}
+
+func (o Options) languageResolver() LanguageResolver {
+	if o.LanguageResolver != nil {
+		return o.LanguageResolver
+	}
+	return TableLanguageResolver{IDs: DefaultLanguageIDs}
+}
+
+func (o Options) genreMapper() GenreMapper {
+	if o.GenreMapper != nil {
+		return o.GenreMapper
+	}
+	return DefaultGenreMapper
+}
+
+func (o Options) sanitizer() *SanitizePolicy {
+	if o.Sanitizer != nil {
+		return o.Sanitizer
+	}
+	return DefaultSanitizePolicy
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// spine page-progression-direction values
+const (
+	PageProgressionLTR = "ltr"
+	PageProgressionRTL = "rtl"
+)
+
+// PageImage is one page of an image-only book, in spine order
+type PageImage struct {
+	Id         string // manifest id of the spine item the page comes from
+	Href       string // full path of the image inside the archive
+	FileName   string
+	MediaType  string
+	File       []byte
+	PageSpread string    // left, right or center, empty when not declared
+	Viewport   *Viewport // page size from the viewport meta, the SVG or the img size, nil when unknown
+}
+
+// pageWrapperElements may wrap the image of an image-only page
+var pageWrapperElements = map[string]bool{
+	"div": true, "p": true, "span": true, "a": true, "figure": true, "section": true, "center": true,
+}
+
+// getPageImageRef returns the reference of the single image a page document consists of,
+// either an img or an svg holding an image. Pages with any text or a second image aren't image pages
+func getPageImageRef(doc *html.Node) (string, *Viewport, bool) {
+	var body *html.Node
+	var findBody func(*html.Node)
+	findBody = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "body" {
+			body = n
+			return
+		}
+		for c := n.FirstChild; c != nil && body == nil; c = c.NextSibling {
+			findBody(c)
+		}
+	}
+	findBody(doc)
+	if body == nil {
+		return "", nil, false
+	}
+
+	ref, images, ok := "", 0, true
+	var size *Viewport
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && ok; c = c.NextSibling {
+			switch c.Type {
+			case html.TextNode:
+				if strings.TrimSpace(c.Data) != "" {
+					ok = false
+				}
+			case html.ElementNode:
+				switch {
+				case c.Data == "img":
+					images++
+					ref, _ = getAttr(c, "src")
+					size = getSizeAttributes(c)
+				case c.Data == "svg":
+					image, isImage := getSVGWrappedImage(c)
+					if !isImage {
+						ok = false
+						break
+					}
+					images++
+					ref = getSVGImageHref(image)
+					size = getSVGViewport(c)
+				case pageWrapperElements[c.Data]:
+					walk(c)
+				default:
+					ok = false
+				}
+			}
+		}
+	}
+	walk(body)
+	if !ok || images != 1 || ref == "" {
+		return "", nil, false
+	}
+	return ref, size, true
+}
+
+// getSVGImageHref reads href, falling back to the xlink:href of SVG 1.1
+func getSVGImageHref(image *html.Node) string {
+	xlinkHref := ""
+	for _, attr := range image.Attr {
+		if attr.Key != "href" {
+			continue
+		}
+		if attr.Namespace == "" {
+			return attr.Val
+		}
+		xlinkHref = attr.Val
+	}
+	return xlinkHref
+}
+
+// getSizeAttributes reads pixel width and height attributes
+func getSizeAttributes(n *html.Node) *Viewport {
+	width, _ := getAttr(n, "width")
+	height, _ := getAttr(n, "height")
+	w, errW := strconv.Atoi(strings.TrimSuffix(width, "px"))
+	h, errH := strconv.Atoi(strings.TrimSuffix(height, "px"))
+	if errW != nil || errH != nil || w == 0 || h == 0 {
+		return nil
+	}
+	return &Viewport{Width: w, Height: h}
+}
+
+// getPageImage resolves the image a page refers to, images outside the manifest are not pages.
+// The image data is read separately since detection alone doesn't need it
+func getPageImage(id string, contentFilePath string, ref string, manifestHrefMap map[string]Item) (PageImage, bool) {
+	imagePath, ok := resolveResourcePath(contentFilePath, ref)
+	if !ok {
+		return PageImage{}, false
+	}
+	item, ok := manifestHrefMap[imagePath]
+	if !ok || !strings.HasPrefix(item.MediaType, "image/") {
+		return PageImage{}, false
+	}
+	return PageImage{
+		Id:        id,
+		Href:      imagePath,
+		FileName:  filepath.Base(imagePath),
+		MediaType: item.MediaType,
+	}, true
+}
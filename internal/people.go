@@ -0,0 +1,71 @@
+package parser
+
+import "sort"
+
+// MARC relator codes that matter most when telling people apart
+const (
+	RoleAuthor      = "aut"
+	RoleAdapter     = "adp"
+	RoleEditor      = "edt"
+	RoleIllustrator = "ill"
+	RoleNarrator    = "nrt"
+	RoleTranslator  = "trl"
+)
+
+type Person struct {
+	Name       string
+	SortName   string // file-as
+	Role       string // MARC relator code, e.g. "trl"
+	RoleLabel  string // human readable relator, e.g. "translator"
+	DisplaySeq int
+	Lang       string
+	Creator    bool // listed as dc:creator rather than dc:contributor
+}
+
+// getPeople merges creators and contributors into one list ordered by display-seq.
+// A creator without any role is treated as an author, which is what reading systems do
+func getPeople(creators *[]Creator, contributors *[]Creator) []Person {
+	var people []Person
+	add := func(list *[]Creator, isCreator bool) {
+		if list == nil {
+			return
+		}
+		for _, c := range *list {
+			if c.Name == "" {
+				continue
+			}
+			person := Person{
+				Name:       c.Name,
+				SortName:   c.FileAs,
+				Role:       c.RawRole,
+				RoleLabel:  c.Role,
+				DisplaySeq: c.DisplaySeq,
+				Lang:       c.Language,
+				Creator:    isCreator,
+			}
+			if person.Role == "" && isCreator {
+				person.Role = RoleAuthor
+				person.RoleLabel = Relator[RoleAuthor]
+			}
+			people = append(people, person)
+		}
+	}
+	add(creators, true)
+	add(contributors, false)
+
+	sort.SliceStable(people, func(i, j int) bool {
+		return lessDisplaySeq(people[i].DisplaySeq, people[j].DisplaySeq)
+	})
+	return people
+}
+
+// PeopleWithRole returns the people listed with the MARC relator code role
+func (m *ResultMetadata) PeopleWithRole(role string) []Person {
+	var people []Person
+	for _, p := range m.People {
+		if p.Role == role {
+			people = append(people, p)
+		}
+	}
+	return people
+}
@@ -0,0 +1,116 @@
+//go:build ignore
+
+// generate builds the n-gram rank profiles of the language detection from a plain text corpus,
+// a directory per language named by its base language code:
+//
+//	go run generate.go -corpus dir -size 2000
+//
+// Each profile file lists the most frequent 1 to 3 character n-grams of its language, most
+// frequent first, one per line with spaces written as underscores. The grams are counted the same
+// way buildNgramProfile counts them in langdetect.go.
+//
+// The shipped profiles were generated from the text of a Debian system: the translated manual
+// pages under /usr/share/man/<lang> and the translations in the gettext catalogs under
+// /usr/share/locale/<lang>/LC_MESSAGES, pt_BR counted as pt. English comes from the untranslated
+// manual pages and the catalogs' original messages. Roff requests and escapes and printf verbs were
+// stripped before counting, this program doesn't do that extraction
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+func main() {
+	corpus := flag.String("corpus", "", "directory holding a directory of UTF-8 text files per language")
+	size := flag.Int("size", 2000, "n-grams kept per language")
+	out := flag.String("out", ".", "directory the profiles are written to")
+	flag.Parse()
+	if *corpus == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, err := os.ReadDir(*corpus)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		lang := entry.Name()
+		counts := make(map[string]int)
+		err := filepath.WalkDir(filepath.Join(*corpus, lang), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			countNgrams(string(data), counts)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeProfile(filepath.Join(*out, lang+".txt"), counts, *size); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %d n-grams\n", lang, len(counts))
+	}
+}
+
+func countNgrams(text string, counts map[string]int) {
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + word + " ")
+		for n := 1; n <= 3; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if gram == " " {
+					continue
+				}
+				counts[gram]++
+			}
+		}
+	}
+}
+
+func writeProfile(path string, counts map[string]int, size int) error {
+	grams := make([]string, 0, len(counts))
+	for gram := range counts {
+		grams = append(grams, gram)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if counts[grams[i]] != counts[grams[j]] {
+			return counts[grams[i]] > counts[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if len(grams) > size {
+		grams = grams[:size]
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, gram := range grams {
+		w.WriteString(strings.ReplaceAll(gram, " ", "_") + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
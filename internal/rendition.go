@@ -0,0 +1,330 @@
+package parser
+
+import (
+	"archive/zip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rendition:layout values
+const (
+	LayoutReflowable   = "reflowable"
+	LayoutPrePaginated = "pre-paginated"
+)
+
+// page spread sides of a spine item
+const (
+	PageSpreadLeft   = "left"
+	PageSpreadRight  = "right"
+	PageSpreadCenter = "center"
+)
+
+const appleDisplayOptionsPath = "META-INF/com.apple.ibooks.display-options.xml"
+
+// Rendition holds the package level rendition properties, empty values mean the reading system default
+type Rendition struct {
+	Layout      string // reflowable or pre-paginated
+	Orientation string // auto, landscape or portrait
+	Spread      string // none, landscape, both or auto
+	Flow        string
+	Viewport    *Viewport // original-resolution of Kindle fixed layout books
+}
+
+// IsFixedLayout reports whether the book is pre-paginated as a whole
+func (r Rendition) IsFixedLayout() bool {
+	return r.Layout == LayoutPrePaginated
+}
+
+type Viewport struct {
+	Width  int
+	Height int
+}
+
+var resolutionPattern = regexp.MustCompile(`^\s*(\d+)\s*[xX]\s*(\d+)\s*$`)
+
+// getRendition reads the rendition:* properties and the Kindle fixed-layout and original-resolution metas,
+// Apple's display options are only consulted when the package declares no layout
+func getRendition(book *Book, metas []Meta) Rendition {
+	rendition := Rendition{}
+	for _, meta := range metas {
+		if meta.Refines != "" {
+			continue
+		}
+		value := strings.TrimSpace(meta.Text)
+		switch meta.Property {
+		case "rendition:layout":
+			rendition.Layout = value
+		case "rendition:orientation":
+			rendition.Orientation = value
+		case "rendition:spread":
+			rendition.Spread = value
+		case "rendition:flow":
+			rendition.Flow = value
+		}
+		switch meta.Name {
+		case "fixed-layout":
+			if strings.EqualFold(strings.TrimSpace(meta.Content), "true") && rendition.Layout == "" {
+				rendition.Layout = LayoutPrePaginated
+			}
+		case "orientation-lock":
+			if rendition.Orientation == "" && meta.Content != "none" {
+				rendition.Orientation = strings.TrimSpace(meta.Content)
+			}
+		case "original-resolution":
+			if m := resolutionPattern.FindStringSubmatch(meta.Content); m != nil {
+				width, _ := strconv.Atoi(m[1])
+				height, _ := strconv.Atoi(m[2])
+				rendition.Viewport = &Viewport{Width: width, Height: height}
+			}
+		}
+	}
+	if rendition.Layout == "" && isAppleFixedLayout(book) {
+		rendition.Layout = LayoutPrePaginated
+	}
+	return rendition
+}
+
+type appleDisplayOptions struct {
+	Platforms []struct {
+		Options []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"option"`
+	} `xml:"platform"`
+}
+
+// isAppleFixedLayout reads the iBooks display options older fixed layout books rely on
+func isAppleFixedLayout(book *Book) bool {
+	options := appleDisplayOptions{}
+	if err := book.ReadXML(appleDisplayOptionsPath, &options); err != nil {
+		return false
+	}
+	for _, platform := range options.Platforms {
+		for _, option := range platform.Options {
+			if option.Name == "fixed-layout" && strings.TrimSpace(option.Value) == "true" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getItemLayout applies the spine itemref rendition overrides to the package layout
+func getItemLayout(itemRef Itemref, rendition Rendition) (layout string, pageSpread string) {
+	layout = rendition.Layout
+	if layout == "" {
+		layout = LayoutReflowable
+	}
+	for _, property := range strings.Fields(itemRef.Properties) {
+		switch property {
+		case "rendition:layout-pre-paginated":
+			layout = LayoutPrePaginated
+		case "rendition:layout-reflowable":
+			layout = LayoutReflowable
+		case "page-spread-left", "rendition:page-spread-left":
+			pageSpread = PageSpreadLeft
+		case "page-spread-right", "rendition:page-spread-right":
+			pageSpread = PageSpreadRight
+		case "rendition:page-spread-center", "rendition:spread-none":
+			pageSpread = PageSpreadCenter
+		}
+	}
+	return layout, pageSpread
+}
+
+// getDocumentViewport reads the viewport meta of an XHTML page or the size of an SVG page
+func getDocumentViewport(doc *html.Node) *Viewport {
+	var viewport *Viewport
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if viewport != nil {
+			return
+		}
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "meta":
+				if name, _ := getAttr(n, "name"); strings.EqualFold(name, "viewport") {
+					content, _ := getAttr(n, "content")
+					viewport = parseViewportContent(content)
+				}
+			case "svg":
+				viewport = getSVGViewport(n)
+				return
+			case "body":
+				// a viewport meta lives in the head, only an SVG page has its size in the body
+				if !isSVGPage(n) {
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	return viewport
+}
+
+// isSVGPage reports whether the only content of body is a single svg element, possibly wrapped in
+// divs, the way SVG content documents and cover wrappers parse
+func isSVGPage(body *html.Node) bool {
+	var only *html.Node
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.ElementNode:
+			if only != nil {
+				return false
+			}
+			only = c
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return false
+			}
+		}
+	}
+	switch {
+	case only == nil:
+		return false
+	case only.Data == "svg":
+		return true
+	case only.Data == "div":
+		return isSVGPage(only)
+	}
+	return false
+}
+
+// parseViewportContent parses "width=1200, height=1600"
+func parseViewportContent(content string) *Viewport {
+	viewport := Viewport{}
+	for _, part := range strings.FieldsFunc(content, func(r rune) bool { return r == ',' || r == ';' }) {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "width":
+			viewport.Width = size
+		case "height":
+			viewport.Height = size
+		}
+	}
+	if viewport.Width == 0 || viewport.Height == 0 {
+		return nil
+	}
+	return &viewport
+}
+
+func getSVGViewport(svg *html.Node) *Viewport {
+	viewBox, ok := getAttr(svg, "viewBox")
+	if !ok {
+		viewBox, ok = getAttr(svg, "viewbox")
+	}
+	if ok {
+		fields := strings.FieldsFunc(viewBox, func(r rune) bool { return r == ' ' || r == ',' })
+		if len(fields) == 4 {
+			width, errW := strconv.ParseFloat(fields[2], 64)
+			height, errH := strconv.ParseFloat(fields[3], 64)
+			if errW == nil && errH == nil && width > 0 && height > 0 {
+				return &Viewport{Width: int(width), Height: int(height)}
+			}
+		}
+	}
+	return getSizeAttributes(svg)
+}
+
+// renderFixedLayoutPage turns a pre-paginated document into a self-contained HTML page: stylesheets
+// are inlined, images, SVG images and CSS backgrounds become data URIs and scripts are removed.
+// Positioning, classes and SVG are kept as the publisher wrote them
+func renderFixedLayoutPage(doc *html.Node, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item, viewport *Viewport, policy *SanitizePolicy) (string, error) {
+	var head *html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode {
+				switch c.Data {
+				case "script":
+					n.RemoveChild(c)
+					c = next
+					continue
+				case "head":
+					head = c
+				case "link":
+					if style := inlineStylesheet(c, r, contentFilePath, manifestHrefMap); style != nil {
+						n.InsertBefore(style, c)
+					}
+					n.RemoveChild(c)
+					c = next
+					continue
+				case "style":
+					if css := mergeStyleText(c); css != "" {
+						c.FirstChild.Data = rewriteCSSURLs(css, contentFilePath, r, manifestHrefMap)
+					}
+				}
+				embedNodeResources(c, r, contentFilePath, manifestHrefMap)
+			}
+			walk(c)
+			c = next
+		}
+	}
+	walk(doc)
+	policy.sanitizeTree(doc)
+
+	if head != nil && viewport != nil && getDocumentViewport(doc) == nil {
+		meta := &html.Node{Type: html.ElementNode, Data: "meta", Attr: []html.Attribute{
+			{Key: "name", Val: "viewport"},
+			{Key: "content", Val: "width=" + strconv.Itoa(viewport.Width) + ", height=" + strconv.Itoa(viewport.Height)},
+		}}
+		head.AppendChild(meta)
+	}
+
+	var b strings.Builder
+	if err := html.Render(&b, doc); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// inlineStylesheet replaces a stylesheet link with a style element holding the sheet
+func inlineStylesheet(link *html.Node, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item) *html.Node {
+	cssPath, ok := getStylesheetPath(link, contentFilePath)
+	if !ok {
+		return nil
+	}
+	css, err := readZipFile(r, cssPath)
+	if err != nil {
+		return nil
+	}
+	style := &html.Node{Type: html.ElementNode, Data: "style"}
+	style.AppendChild(&html.Node{Type: html.TextNode, Data: rewriteCSSURLs(string(toUTF8(css)), cssPath, r, manifestHrefMap)})
+	return style
+}
+
+// embedNodeResources turns the src, SVG href and inline style url() references of n into data URIs
+func embedNodeResources(n *html.Node, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item) {
+	for i, attr := range n.Attr {
+		switch {
+		case attr.Key == "src" && (n.Data == "img" || n.Data == "input"):
+			if dataURI, ok := embedResource(r, contentFilePath, attr.Val, manifestHrefMap); ok {
+				n.Attr[i].Val = dataURI
+			}
+		case (attr.Key == "src" || attr.Key == "poster") && mediaSourceElements[n.Data]:
+			if dataURI, ok := embedMediaFile(r, contentFilePath, attr.Val, manifestHrefMap); ok {
+				n.Attr[i].Val = dataURI
+			}
+		case attr.Key == "href" && n.Data == "image":
+			if dataURI, ok := embedResource(r, contentFilePath, attr.Val, manifestHrefMap); ok {
+				n.Attr[i].Val = dataURI
+			}
+		case attr.Key == "style":
+			n.Attr[i].Val = rewriteCSSURLs(attr.Val, contentFilePath, r, manifestHrefMap)
+		}
+	}
+}
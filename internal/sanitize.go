@@ -0,0 +1,328 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// SanitizePolicy is the allowlist chapter HTML is rendered through. Elements that are neither
+// allowed nor dropped are unwrapped, their content is kept without the tag. Attribute names of
+// namespaced attributes include the prefix, e.g. "epub:type" and "xlink:href"
+type SanitizePolicy struct {
+	Elements          map[string]bool            // allowed elements
+	DropElements      map[string]bool            // removed together with their content
+	Attributes        map[string]bool            // allowed on every element
+	ElementAttributes map[string]map[string]bool // allowed on one element only
+	URLAttributes     map[string]bool            // attributes holding a URL, checked against URLSchemes
+	URLSchemes        map[string]bool            // allowed URL schemes, relative URLs are always allowed
+	DataImages        bool                       // allow data:image/ URLs in src and CSS url()
+	DataMedia         bool                       // allow data:audio/, data:video/ and data:text/vtt URLs in media sources
+}
+
+type elementAction int
+
+const (
+	elementAllow elementAction = iota
+	elementUnwrap
+	elementDrop
+)
+
+var dangerousCSSPattern = regexp.MustCompile(`(?i)expression\s*\(|behavior\s*:|-moz-binding\s*:|@import`)
+
+// presentation attributes of the SVG elements in DefaultSanitizePolicy
+var svgPresentationAttributes = []string{
+	"transform", "fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-opacity",
+	"stroke-linecap", "stroke-linejoin", "stroke-miterlimit", "stroke-dasharray", "stroke-dashoffset",
+	"opacity", "color", "display", "visibility", "clip-path", "clip-rule", "mask", "marker-start",
+	"marker-mid", "marker-end", "font-family", "font-size", "font-style", "font-weight",
+	"text-anchor", "dominant-baseline", "letter-spacing", "word-spacing", "stop-color", "stop-opacity",
+	"vector-effect",
+}
+
+// attributes every MathML element of DefaultSanitizePolicy may carry
+var mathGlobalAttributes = []string{"mathvariant", "mathsize", "mathcolor", "mathbackground", "displaystyle", "scriptlevel"}
+
+func mathAttributes(attributes ...string) map[string]bool {
+	return setOf(append(attributes, mathGlobalAttributes...)...)
+}
+
+func svgAttributes(attributes ...string) map[string]bool {
+	return setOf(append(attributes, svgPresentationAttributes...)...)
+}
+
+func setOf(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// DefaultSanitizePolicy keeps the text semantics and images of a chapter and removes anything
+// able to run script, load remote frames or submit data
+var DefaultSanitizePolicy = &SanitizePolicy{
+	Elements: setOf(
+		"html", "head", "body", "title", "meta", "style",
+		"a", "abbr", "address", "article", "aside", "b", "bdi", "bdo", "big", "blockquote", "br",
+		"caption", "center", "cite", "code", "col", "colgroup", "dd", "del", "details", "dfn", "div",
+		"dl", "dt", "em", "figcaption", "figure", "footer", "h1", "h2", "h3", "h4", "h5", "h6",
+		"header", "hgroup", "hr", "i", "img", "ins", "kbd", "li", "main", "mark", "nav", "ol", "p",
+		"pre", "q", "rb", "rp", "rt", "rtc", "ruby", "s", "samp", "section", "small", "span", "strike",
+		"strong", "sub", "summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "time",
+		"tr", "tt", "u", "ul", "var", "wbr", "audio", "video", "source", "track",
+		"svg", "g", "image", "desc", "defs", "symbol", "use", "path", "rect", "circle", "ellipse",
+		"line", "polyline", "polygon", "text", "tspan", "textPath", "linearGradient", "radialGradient",
+		"stop", "clipPath", "mask", "pattern", "marker",
+
+		"math", "mrow", "mi", "mn", "mo", "ms", "mtext", "mspace", "mfrac", "msqrt", "mroot", "msup",
+		"msub", "msubsup", "munder", "mover", "munderover", "mmultiscripts", "mprescripts", "none",
+		"mtable", "mtr", "mlabeledtr", "mtd", "mstyle", "mpadded", "mphantom", "menclose", "merror",
+		"mfenced", "semantics", "annotation",
+	),
+	DropElements: setOf(
+		"script", "noscript", "iframe", "frame", "frameset", "object", "embed", "applet", "param",
+		"base", "template", "input", "button", "select", "textarea", "option", "optgroup",
+		// SVG animation can set attributes such as href after sanitization, foreignObject holds HTML
+		"animate", "animateMotion", "animateTransform", "set", "foreignObject",
+		// annotation-xml can hold HTML and SVG, the annotation text and presentation markup remain
+		"annotation-xml",
+	),
+	Attributes: setOf(
+		"id", "class", "title", "lang", "xml:lang", "dir", "style", "role", "epub:type", "hidden",
+	),
+	ElementAttributes: map[string]map[string]bool{
+		"a":              setOf("href", "xlink:href", "name", "rel"),
+		"p":              setOf("align"),
+		"div":            setOf("align"),
+		"h1":             setOf("align"),
+		"h2":             setOf("align"),
+		"h3":             setOf("align"),
+		"h4":             setOf("align"),
+		"h5":             setOf("align"),
+		"h6":             setOf("align"),
+		"caption":        setOf("align"),
+		"img":            setOf("src", "alt", "width", "height"),
+		"blockquote":     setOf("cite", "align"),
+		"q":              setOf("cite"),
+		"del":            setOf("cite", "datetime"),
+		"ins":            setOf("cite", "datetime"),
+		"time":           setOf("datetime"),
+		"ol":             setOf("start", "type", "reversed"),
+		"ul":             setOf("type"),
+		"li":             setOf("value"),
+		"col":            setOf("span", "width"),
+		"colgroup":       setOf("span", "width"),
+		"td":             setOf("colspan", "rowspan", "headers", "align", "valign"),
+		"th":             setOf("colspan", "rowspan", "headers", "scope", "abbr", "align", "valign"),
+		"table":          setOf("summary", "width", "border", "cellpadding", "cellspacing"),
+		"details":        setOf("open"),
+		"audio":          setOf("src", "controls", "loop", "muted", "preload"),
+		"video":          setOf("src", "poster", "controls", "loop", "muted", "preload", "playsinline", "width", "height"),
+		"source":         setOf("src", "type", "media"),
+		"track":          setOf("src", "kind", "srclang", "label", "default"),
+		"meta":           setOf("name", "content", "charset"),
+		"style":          setOf("type", "media"),
+		"svg":            svgAttributes("xmlns", "xmlns:xlink", "version", "viewBox", "width", "height", "preserveAspectRatio", "x", "y"),
+		"g":              svgAttributes(),
+		"defs":           svgAttributes(),
+		"symbol":         svgAttributes("viewBox", "preserveAspectRatio"),
+		"use":            svgAttributes("href", "xlink:href", "x", "y", "width", "height"),
+		"image":          svgAttributes("href", "xlink:href", "width", "height", "x", "y", "preserveAspectRatio"),
+		"path":           svgAttributes("d", "pathLength"),
+		"rect":           svgAttributes("x", "y", "width", "height", "rx", "ry"),
+		"circle":         svgAttributes("cx", "cy", "r"),
+		"ellipse":        svgAttributes("cx", "cy", "rx", "ry"),
+		"line":           svgAttributes("x1", "y1", "x2", "y2"),
+		"polyline":       svgAttributes("points"),
+		"polygon":        svgAttributes("points"),
+		"text":           svgAttributes("x", "y", "dx", "dy", "rotate", "textLength", "lengthAdjust"),
+		"tspan":          svgAttributes("x", "y", "dx", "dy", "rotate", "textLength", "lengthAdjust"),
+		"textPath":       svgAttributes("href", "xlink:href", "startOffset", "method", "spacing"),
+		"linearGradient": svgAttributes("x1", "y1", "x2", "y2", "gradientUnits", "gradientTransform", "spreadMethod", "href", "xlink:href"),
+		"radialGradient": svgAttributes("cx", "cy", "r", "fx", "fy", "gradientUnits", "gradientTransform", "spreadMethod", "href", "xlink:href"),
+		"stop":           svgAttributes("offset"),
+		"clipPath":       svgAttributes("clipPathUnits"),
+		"mask":           svgAttributes("x", "y", "width", "height", "maskUnits", "maskContentUnits"),
+		"pattern":        svgAttributes("x", "y", "width", "height", "viewBox", "patternUnits", "patternContentUnits", "patternTransform"),
+		"marker":         svgAttributes("viewBox", "refX", "refY", "markerWidth", "markerHeight", "markerUnits", "orient"),
+		"math":           mathAttributes("display", "alttext", "xmlns"),
+		"mi":             mathAttributes(),
+		"mn":             mathAttributes(),
+		"mo":             mathAttributes("form", "fence", "separator", "stretchy", "symmetric", "largeop", "movablelimits", "accent", "lspace", "rspace", "minsize", "maxsize"),
+		"ms":             mathAttributes("lquote", "rquote"),
+		"mtext":          mathAttributes(),
+		"mspace":         mathAttributes("width", "height", "depth"),
+		"mfrac":          mathAttributes("linethickness", "numalign", "denomalign", "bevelled"),
+		"mrow":           mathAttributes(),
+		"msqrt":          mathAttributes(),
+		"mroot":          mathAttributes(),
+		"msup":           mathAttributes(),
+		"msub":           mathAttributes(),
+		"msubsup":        mathAttributes(),
+		"munder":         mathAttributes("accentunder"),
+		"mover":          mathAttributes("accent"),
+		"munderover":     mathAttributes("accent", "accentunder"),
+		"mmultiscripts":  mathAttributes(),
+		"mtable":         mathAttributes("columnalign", "rowalign", "columnspacing", "rowspacing", "columnlines", "rowlines", "frame"),
+		"mtr":            mathAttributes("columnalign", "rowalign"),
+		"mlabeledtr":     mathAttributes("columnalign", "rowalign"),
+		"mtd":            mathAttributes("columnalign", "rowalign", "columnspan", "rowspan"),
+		"mstyle":         mathAttributes(),
+		"mpadded":        mathAttributes("width", "height", "depth", "lspace", "voffset"),
+		"mphantom":       mathAttributes(),
+		"menclose":       mathAttributes("notation"),
+		"merror":         mathAttributes(),
+		"mfenced":        mathAttributes("open", "close", "separators"),
+		"semantics":      mathAttributes(),
+		"annotation":     mathAttributes("encoding"),
+	},
+	URLAttributes: setOf("href", "src", "cite", "xlink:href", "action", "formaction", "poster", "background", "longdesc"),
+	URLSchemes:    setOf("http", "https", "mailto"),
+	DataImages:    true,
+	DataMedia:     true,
+}
+
+func (p *SanitizePolicy) elementAction(tag string) elementAction {
+	switch {
+	case p.DropElements[tag]:
+		return elementDrop
+	case p.Elements[tag]:
+		return elementAllow
+	}
+	return elementUnwrap
+}
+
+// attributeName gives namespaced attributes of foreign elements back their xml, xlink or xmlns prefix
+func attributeName(attr html.Attribute) string {
+	if attr.Namespace == "xmlns" && attr.Key == "xmlns" {
+		return "xmlns"
+	}
+	if attr.Namespace != "" {
+		return attr.Namespace + ":" + attr.Key
+	}
+	return attr.Key
+}
+
+// sanitizeAttributes returns the attributes of n the policy allows, n itself is left unchanged
+func (p *SanitizePolicy) sanitizeAttributes(n *html.Node) []html.Attribute {
+	var attrs []html.Attribute
+	for _, attr := range n.Attr {
+		name := attributeName(attr)
+		lowerName := strings.ToLower(name)
+		allowed := p.Attributes[name] || p.ElementAttributes[n.Data][name] || strings.HasPrefix(lowerName, "aria-")
+		// event handlers are never allowed, whatever the policy lists
+		if !allowed || strings.HasPrefix(lowerName, "on") {
+			continue
+		}
+		image := n.Data == "img" || n.Data == "image" || (n.Data == "video" && name == "poster")
+		if p.URLAttributes[name] && !p.allowURL(attr.Val, image) && !p.allowMediaURL(attr.Val, n.Data) {
+			continue
+		}
+		if p.URLAttributes[name] && n.Namespace == "svg" && n.Data != "a" && n.Data != "image" && !strings.HasPrefix(attr.Val, "#") {
+			// use, gradients and text paths only reference elements of the same document
+			continue
+		}
+		if (lowerName == "style" || strings.Contains(strings.ToLower(attr.Val), "url(")) && !p.allowCSS(attr.Val) {
+			// fill, stroke, clip-path and the like take url() references too
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// allowURL checks the scheme of a URL the way browsers read it, ignoring the whitespace and
+// control characters that "java\tscript:" relies on
+func (p *SanitizePolicy) allowURL(value string, image bool) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+	colon := strings.IndexByte(cleaned, ':')
+	if colon < 0 || strings.ContainsAny(cleaned[:colon], "/?#") {
+		// relative URL
+		return true
+	}
+	scheme := strings.ToLower(cleaned[:colon])
+	if scheme == "data" {
+		return image && p.DataImages && strings.HasPrefix(strings.ToLower(cleaned), "data:image/")
+	}
+	return p.URLSchemes[scheme]
+}
+
+// allowMediaURL allows the data URIs audio and video sources and text tracks are embedded as
+func (p *SanitizePolicy) allowMediaURL(value string, tag string) bool {
+	if !p.DataMedia || !mediaSourceElements[tag] {
+		return false
+	}
+	lower := strings.ToLower(strings.TrimSpace(value))
+	return strings.HasPrefix(lower, "data:audio/") || strings.HasPrefix(lower, "data:video/") || strings.HasPrefix(lower, "data:text/vtt")
+}
+
+// escapeStyleText keeps CSS from closing the style element it is rendered in, "<\/" is the same
+// character pair to the CSS parser
+func escapeStyleText(css string) string {
+	return strings.ReplaceAll(css, "</", `<\/`)
+}
+
+// allowCSS rejects script-capable CSS and url() references to disallowed schemes
+func (p *SanitizePolicy) allowCSS(css string) bool {
+	if dangerousCSSPattern.MatchString(css) {
+		return false
+	}
+	for _, match := range cssURLPattern.FindAllStringSubmatch(css, -1) {
+		if !p.allowURL(match[2], true) {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeTree applies the policy to a whole document in place, for output that is rendered
+// from the parsed tree as a whole rather than node by node
+func (p *SanitizePolicy) sanitizeTree(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.CommentNode:
+			n.RemoveChild(c)
+		case html.ElementNode:
+			switch p.elementAction(c.Data) {
+			case elementDrop:
+				n.RemoveChild(c)
+				c = next
+				continue
+			case elementUnwrap:
+				first := c.FirstChild
+				for child := c.FirstChild; child != nil; child = c.FirstChild {
+					c.RemoveChild(child)
+					n.InsertBefore(child, c)
+				}
+				n.RemoveChild(c)
+				if first != nil {
+					next = first
+				}
+				c = next
+				continue
+			}
+			c.Attr = p.sanitizeAttributes(c)
+			if c.Data == "style" {
+				css := mergeStyleText(c)
+				if css != "" && !p.allowCSS(css) {
+					n.RemoveChild(c)
+					c = next
+					continue
+				}
+				if c.FirstChild != nil {
+					c.FirstChild.Data = escapeStyleText(css)
+				}
+			}
+			p.sanitizeTree(c)
+		}
+		c = next
+	}
+}
@@ -0,0 +1,309 @@
+package parser
+
+import (
+	"archive/zip"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func renderChapter(t *testing.T, source string, policy *SanitizePolicy) string {
+	doc, err := html.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	extractRawHTML(doc, &b, nil, "OEBPS/chapter.xhtml", map[string]Item{}, policy)
+	return b.String()
+}
+
+func Test_sanitize_xss_vectors(t *testing.T) {
+	vectors := []string{
+		`<p onclick="alert(1)">text</p>`,
+		`<body onload="alert(1)"><p>text</p></body>`,
+		`<img src="missing.png" onerror="alert(1)"/>`,
+		`<a href="javascript:alert(1)">link</a>`,
+		`<a href="  JaVaScRiPt:alert(1)">link</a>`,
+		`<a href="java&#x09;script:alert(1)">link</a>`,
+		`<a href="&#106;avascript:alert(1)">link</a>`,
+		`<a href="vbscript:alert(1)">link</a>`,
+		`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">link</a>`,
+		`<iframe src="https://example.com" srcdoc="<script>alert(1)</script>"></iframe>`,
+		`<object data="movie.swf"><param name="x" value="alert(1)"/></object>`,
+		`<embed src="javascript:alert(1)"/>`,
+		`<form action="javascript:alert(1)"><input onfocus="alert(1)" autofocus/><button formaction="javascript:alert(1)">go</button></form>`,
+		`<div style="background: url(javascript:alert(1))">text</div>`,
+		`<div style="width: expression(alert(1))">text</div>`,
+		`<span style="-moz-binding: url(http://example.com/xss.xml#alert)">text</span>`,
+		`<noscript><p title="</noscript><img src=x onerror=alert(1)>"></p></noscript>`,
+		`<math><maction actiontype="statusline" xlink:href="javascript:alert(1)">click</maction></math>`,
+		`<details open ontoggle="alert(1)"><summary>s</summary></details>`,
+		`<base href="javascript:alert(1)//"/><a href="x">link</a>`,
+		`<template><img src="x" onerror="alert(1)"/></template>`,
+		`<blockquote cite="javascript:alert(1)">quote</blockquote>`,
+	}
+	for _, vector := range vectors {
+		output := strings.ToLower(renderChapter(t, "<html><body>"+vector+"</body></html>", DefaultSanitizePolicy))
+		for _, unsafe := range []string{"alert", "script", "<iframe", "<object", "<embed", "<form", "<input", "<button", "<base", "srcdoc", "onerror", "onclick", "onload", "data:text"} {
+			if strings.Contains(output, unsafe) {
+				t.Logf("%s rendered as %s still contains %s", vector, output, unsafe)
+				t.Fail()
+			}
+		}
+	}
+
+	// the text of raw text elements, which the policy unwraps, is markup only when written unescaped
+	rawTextVectors := []string{
+		`<xmp><img src=x onerror=alert(1)></xmp>`,
+		`<noembed><img src=x onerror=alert(2)></noembed>`,
+		`<noframes><img src=x onerror=alert(3)></noframes>`,
+		`<plaintext><img src=x onerror=alert(4)>`,
+	}
+	for _, vector := range rawTextVectors {
+		output := renderChapter(t, "<html><body>"+vector+"</body></html>", DefaultSanitizePolicy)
+		if strings.Contains(output, "<img") || !strings.Contains(output, "&lt;img") {
+			t.Logf("%s rendered as %s, expected the markup as text", vector, output)
+			t.Fail()
+		}
+	}
+
+	// the XML parser turns escaped markup into the same text nodes
+	doc, err := parseContentDocument([]byte(`<html xmlns="http://www.w3.org/1999/xhtml"><body><xmp>&lt;img src=x onerror=alert(5)&gt;</xmp></body></html>`), "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	extractRawHTML(doc, &b, nil, "OEBPS/chapter.xhtml", map[string]Item{}, DefaultSanitizePolicy)
+	if output := b.String(); strings.Contains(output, "<img") {
+		t.Logf("the XHTML xmp rendered as %s", output)
+		t.Fail()
+	}
+}
+
+func Test_sanitize_keeps_content(t *testing.T) {
+	output := renderChapter(t, `<html><body>
+<section epub:type="chapter" xml:lang="en"><h1 id="c1">One</h1>
+<p>See <a href="chapter2.xhtml#note1" epub:type="noteref">the note</a> or <a href="https://example.com">the site</a>.</p>
+<p><font color="red">kept text</font></p>
+<table><tr><td colspan="2" aria-label="cell">x</td></tr></table></section>
+</body></html>`, DefaultSanitizePolicy)
+
+	for _, expected := range []string{
+		`epub:type="chapter"`, `xml:lang="en"`, `href="chapter2.xhtml#note1"`, `href="https://example.com"`,
+		`kept text`, `colspan="2"`, `aria-label="cell"`, `<h1 id="c1">`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+	if strings.Contains(output, "<font") {
+		t.Logf("font should be unwrapped in %s", output)
+		t.Fail()
+	}
+
+	custom := &SanitizePolicy{Elements: setOf("p"), URLSchemes: setOf("https")}
+	output = renderChapter(t, `<html><body><p title="t">a <a href="https://example.com">b</a></p></body></html>`, custom)
+	if output != "<p>a b</p>" {
+		t.Logf("custom policy output expected <p>a b</p> but is %s", output)
+		t.Fail()
+	}
+}
+
+func Test_sanitize_tree(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><meta http-equiv="refresh" content="0;url=javascript:alert(1)"/>
+<style>div { background: url(javascript:alert(1)) }</style></head>
+<body><div onclick="alert(1)" style="position:absolute;left:10px"><iframe src="x"></iframe>
+<svg viewBox="0 0 10 10" onload="alert(1)"><image xlink:href="javascript:alert(1)" width="10"/></svg></div></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	DefaultSanitizePolicy.sanitizeTree(doc)
+	var b strings.Builder
+	if err := html.Render(&b, doc); err != nil {
+		t.Fatal(err)
+	}
+	output := b.String()
+	// the meta content is inert once http-equiv is gone
+	for _, unsafe := range []string{"http-equiv", "url(javascript", `href="javascript`, "<iframe", "onclick", "onload"} {
+		if strings.Contains(output, unsafe) {
+			t.Logf("%s still contains %s", output, unsafe)
+			t.Fail()
+		}
+	}
+	for _, expected := range []string{`style="position:absolute;left:10px"`, `viewBox="0 0 10 10"`, `width="10"`} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+}
+
+// hasElement reports whether a browser parsing output would create a tag element
+func hasElement(t *testing.T, output string, tag string) bool {
+	doc, err := html.Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		found = found || (n.Type == html.ElementNode && n.Data == tag)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return found
+}
+
+func Test_fixed_layout_style_breakout(t *testing.T) {
+	r, err := zip.OpenReader("../fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	doc, err := parseContentDocument([]byte(`<html xmlns="http://www.w3.org/1999/xhtml"><head>
+<style>p{}&lt;/style&gt;&lt;img src=x onerror=alert(1)&gt;</style></head><body><p>page</p></body></html>`), "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := renderFixedLayoutPage(doc, r, "OEBPS/page.xhtml", map[string]Item{}, nil, DefaultSanitizePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasElement(t, output, "img") {
+		t.Logf("the style text broke out of its element: %s", output)
+		t.Fail()
+	}
+
+	// a linked stylesheet is inlined into a style element the same way
+	link := &html.Node{Type: html.ElementNode, Data: "link", Attr: []html.Attribute{{Key: "rel", Val: "stylesheet"}, {Key: "href", Val: "0.css"}}}
+	style := inlineStylesheet(link, r, "OEBPS/page.xhtml", map[string]Item{})
+	if style == nil {
+		t.Fatal("expected the fixture stylesheet to be inlined")
+	}
+	style.FirstChild.Data += "</style><img src=x onerror=alert(1)>"
+	doc, _ = html.Parse(strings.NewReader(`<html><head></head><body><p>page</p></body></html>`))
+	doc.FirstChild.FirstChild.AppendChild(style)
+	DefaultSanitizePolicy.sanitizeTree(doc)
+	var b strings.Builder
+	if err := html.Render(&b, doc); err != nil {
+		t.Fatal(err)
+	}
+	if output := b.String(); hasElement(t, output, "img") {
+		t.Logf("the inlined stylesheet broke out of its element: %.300s", output)
+		t.Fail()
+	}
+}
+
+func Test_comment_split_style(t *testing.T) {
+	// the XML comment splits the style text, the @import is in the second text node
+	page := []byte(`<html xmlns="http://www.w3.org/1999/xhtml"><head>
+<style> <!-- -->@import url(https://evil.example/x.css); p { color: red }</style></head><body><p>page</p></body></html>`)
+	doc, err := parseContentDocument(page, "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	style := findElement(doc, "style")
+	if style == nil || style.FirstChild == style.LastChild {
+		t.Fatal("expected the style text split around the comment")
+	}
+	DefaultSanitizePolicy.sanitizeTree(doc)
+	var b strings.Builder
+	if err := html.Render(&b, doc); err != nil {
+		t.Fatal(err)
+	}
+	if output := b.String(); strings.Contains(output, "@import") {
+		t.Logf("expected the @import removed by sanitizeTree: %s", output)
+		t.Fail()
+	}
+
+	r, err := zip.OpenReader("../fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	doc, err = parseContentDocument(page, "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := renderFixedLayoutPage(doc, r, "OEBPS/page.xhtml", map[string]Item{}, nil, DefaultSanitizePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, "@import") || strings.Contains(output, "evil.example") {
+		t.Logf("expected the @import removed from the fixed layout page: %s", output)
+		t.Fail()
+	}
+}
+
+func Test_sanitize_svg(t *testing.T) {
+	output := renderChapter(t, `<p>Figure</p><svg viewBox="0 0 10 10" onload="alert(1)">
+<defs><linearGradient id="g"><stop offset="0" stop-color="red"/></linearGradient></defs>
+<script>alert(1)</script><foreignObject><div>html</div></foreignObject>
+<set attributeName="href" to="javascript:alert(1)"/>
+<path d="M0 0L10 10" fill="url(#g)" stroke="url(javascript:alert(1))"/>
+<use href="#g"/><use xlink:href="http://example.com/sprite.svg#icon"/>
+<a xlink:href="javascript:alert(1)"><text x="1" y="5">label</text></a>
+<image href="http://example.com/tracker.png"/>
+</svg>`, DefaultSanitizePolicy)
+
+	for _, expected := range []string{
+		`<svg viewBox="0 0 10 10">`,
+		`<linearGradient id="g"><stop offset="0" stop-color="red"/></linearGradient>`,
+		`<path d="M0 0L10 10" fill="url(#g)"/>`,
+		`<use href="#g"/><use/>`,
+		`<a><text x="1" y="5">label</text></a>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Logf("expected %s in %s", expected, output)
+			t.Fail()
+		}
+	}
+	for _, unexpected := range []string{"alert", "html", "example.com", "<image", "<set"} {
+		if strings.Contains(output, unexpected) {
+			t.Logf("expected %q to be removed from %s", unexpected, output)
+			t.Fail()
+		}
+	}
+}
+
+func Test_svg_image_wrapper(t *testing.T) {
+	r, err := zip.OpenReader("../fixtures/drjekyllmrhyde_v3.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := readZipFile(r, "OEBPS/wrap0000.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := parseContentDocument(data, "application/xhtml+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestHrefMap := map[string]Item{
+		"OEBPS/7337271621053197105_cover.jpg": {Href: "7337271621053197105_cover.jpg", MediaType: "image/jpeg"},
+	}
+	var b strings.Builder
+	extractRawHTML(doc, &b, r, "OEBPS/wrap0000.xhtml", manifestHrefMap, DefaultSanitizePolicy)
+	output := b.String()
+	if !strings.Contains(output, `<img alt="" src="data:image/jpeg;base64,`) || strings.Contains(output, "<svg") {
+		t.Logf("expected the cover svg as an embedded img but got %.200s", output)
+		t.Fail()
+	}
+
+	// an svg drawing around the image stays an svg, with the image embedded
+	doc, err = html.Parse(strings.NewReader(`<svg viewBox="0 0 500 862"><image xlink:href="7337271621053197105_cover.jpg"/><rect width="10" height="10"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	extractRawHTML(doc, &b, r, "OEBPS/wrap0000.xhtml", manifestHrefMap, DefaultSanitizePolicy)
+	if output := b.String(); !strings.Contains(output, `<image href="data:image/jpeg;base64,`) {
+		t.Logf("expected the svg image embedded but got %.200s", output)
+		t.Fail()
+	}
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// voidElements have no end tag in the HTML syntax
+var voidElements = setOf(
+	"area", "base", "br", "col", "embed", "hr", "img", "input", "keygen", "link", "meta", "param",
+	"source", "track", "wbr",
+)
+
+// rawTextElements hold text that is written out unescaped
+var rawTextElements = setOf("style", "script", "xmp", "iframe", "noembed", "noframes", "plaintext", "noscript")
+
+var (
+	textEscaper      = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	attributeEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", `"`, "&quot;")
+)
+
+// writeText writes a text node whose parent element is written too, the text of raw text
+// elements goes out unescaped
+func writeText(w io.StringWriter, n *html.Node) {
+	if n.Parent != nil && n.Parent.Type == html.ElementNode && rawTextElements[n.Parent.Data] {
+		w.WriteString(n.Data)
+		return
+	}
+	w.WriteString(textEscaper.Replace(n.Data))
+}
+
+// writeUnwrappedText writes a text node whose parent element is left out. Without its raw text
+// parent the text is ordinary text and has to be escaped, or markup in it would come alive
+func writeUnwrappedText(w io.StringWriter, n *html.Node) {
+	w.WriteString(textEscaper.Replace(n.Data))
+}
+
+// writeStartTag writes the start tag of n with attrs, foreign elements without children are self-closed
+func writeStartTag(w io.StringWriter, n *html.Node, attrs []html.Attribute) {
+	w.WriteString("<")
+	w.WriteString(n.Data)
+	for _, attr := range attrs {
+		w.WriteString(" ")
+		w.WriteString(attributeName(attr))
+		w.WriteString(`="`)
+		w.WriteString(attributeEscaper.Replace(attr.Val))
+		w.WriteString(`"`)
+	}
+	if n.Namespace != "" && n.FirstChild == nil {
+		w.WriteString("/>")
+		return
+	}
+	w.WriteString(">")
+	// the parser drops a newline right after these start tags, so one that is content needs doubling
+	switch n.Data {
+	case "pre", "textarea", "listing":
+		if c := n.FirstChild; c != nil && c.Type == html.TextNode && strings.HasPrefix(c.Data, "\n") {
+			w.WriteString("\n")
+		}
+	}
+}
+
+// writeEndTag closes n unless it's a void or self-closed element
+func writeEndTag(w io.StringWriter, n *html.Node) {
+	if n.Namespace == "" && voidElements[n.Data] {
+		return
+	}
+	if n.Namespace != "" && n.FirstChild == nil {
+		return
+	}
+	w.WriteString("</")
+	w.WriteString(n.Data)
+	w.WriteString(">")
+}
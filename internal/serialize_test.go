@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"testing"
+)
+
+func Test_serialize_round_trip(t *testing.T) {
+	policy := &SanitizePolicy{
+		Elements:      setOf("p", "br", "hr", "img", "pre", "span", "a", "math", "mi", "mo", "table", "tbody", "tr", "td", "wbr"),
+		Attributes:    setOf("id", "title", "epub:type", "xml:lang", "lang", "xlink:href", "xmlns"),
+		URLAttributes: setOf("xlink:href"),
+	}
+	cases := map[string]string{
+		`<p>a<br>b<wbr>c<img></p><hr>`:                               `<p>a<br>b<wbr>c<img></p><hr>`,
+		`<p>1 &lt; 2 &amp;&amp; 3 &gt; 2</p>`:                        `<p>1 &lt; 2 &amp;&amp; 3 &gt; 2</p>`,
+		`<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`:               `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`,
+		`<p title="say &quot;hi&quot; &amp; go">x</p>`:               `<p title="say &quot;hi&quot; &amp; go">x</p>`,
+		`<p>non&nbsp;breaking</p>`:                                   `<p>non&nbsp;breaking</p>`,
+		`<span epub:type="pagebreak" id="p1" title="1"></span>`:      `<span epub:type="pagebreak" id="p1" title="1"></span>`,
+		`<p xml:lang="fr" lang="fr">oui</p>`:                         `<p xml:lang="fr" lang="fr">oui</p>`,
+		`<math xml:lang="en"><mi xlink:href="#n">x</mi><mo/></math>`: `<math xml:lang="en"><mi xlink:href="#n">x</mi><mo/></math>`,
+		"<pre>\n\nindented</pre>":                                    "<pre>\n\nindented</pre>",
+		`<table><tr><td>cell</td></tr></table>`:                      `<table><tbody><tr><td>cell</td></tr></tbody></table>`,
+	}
+	for source, expected := range cases {
+		output := renderChapter(t, "<html><body>"+source+"</body></html>", policy)
+		if output != expected {
+			t.Logf("%s expected to serialize as %s but is %s", source, expected, output)
+			t.Fail()
+			continue
+		}
+		again := renderChapter(t, "<html><body>"+output+"</body></html>", policy)
+		if again != output {
+			t.Logf("%s doesn't round trip, %s became %s", source, output, again)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// collection-type values defined by EPUB 3
+const (
+	CollectionTypeSeries = "series"
+	CollectionTypeSet    = "set"
+)
+
+type Series struct {
+	Name       string
+	Type       string  // collection-type, calibre series are always "series"
+	Position   float64 // group-position or calibre:series_index, 0 when unknown
+	Identifier string  // dcterms:identifier refinement
+}
+
+// getSeries reads EPUB 3 belongs-to-collection metas and the calibre:series convention.
+// Only top level collections are returned, a collection refining another collection is skipped
+func getSeries(metas []Meta) []Series {
+	metaMap := *getMetaMap(metas)
+	var series []Series
+	for _, meta := range metas {
+		if meta.Property != "belongs-to-collection" || meta.Refines != "" {
+			continue
+		}
+		name := strings.TrimSpace(meta.Text)
+		if name == "" {
+			continue
+		}
+		series = append(series, Series{
+			Name:       name,
+			Type:       strings.TrimSpace(getMetadata(metaMap, meta.Id, "collection-type")),
+			Position:   parseSeriesPosition(getMetadata(metaMap, meta.Id, "group-position")),
+			Identifier: strings.TrimSpace(getMetadata(metaMap, meta.Id, "dcterms:identifier")),
+		})
+	}
+
+	calibreSeries, calibreIndex := "", ""
+	for _, meta := range metas {
+		switch meta.Name {
+		case "calibre:series":
+			calibreSeries = strings.TrimSpace(meta.Content)
+		case "calibre:series_index":
+			calibreIndex = meta.Content
+		}
+	}
+	if calibreSeries != "" {
+		for _, s := range series {
+			if strings.EqualFold(s.Name, calibreSeries) {
+				return series
+			}
+		}
+		series = append(series, Series{
+			Name:     calibreSeries,
+			Type:     CollectionTypeSeries,
+			Position: parseSeriesPosition(calibreIndex),
+		})
+	}
+	return series
+}
+
+func parseSeriesPosition(position string) float64 {
+	p, err := strconv.ParseFloat(strings.TrimSpace(position), 64)
+	if err != nil {
+		return 0
+	}
+	return p
+}
@@ -0,0 +1,207 @@
+package parser
+
+import (
+	"archive/zip"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// the chapter HTML has no html or body element, the scope container stands in for them
+var rootCompoundPattern = regexp.MustCompile(`(?i)^(?:html|body|:root)(?:[.#:\[][^\s>+~]*)?(?:\s*>\s*|\s+|$)`)
+
+// bookStyles collects the stylesheets of a book in PreserveStyles mode, each sheet once, with
+// their rules scoped under the book's container class and their resources embedded
+type bookStyles struct {
+	scope  string
+	policy *SanitizePolicy
+	seen   map[string]bool
+	css    strings.Builder
+}
+
+func newBookStyles(scope string, policy *SanitizePolicy) *bookStyles {
+	return &bookStyles{scope: scope, policy: policy, seen: make(map[string]bool)}
+}
+
+// getStyleScope names the container class of a book after its identifier and package path
+func getStyleScope(identifier string, packagePath string) string {
+	h := fnv.New32a()
+	h.Write([]byte(identifier + "\x00" + packagePath))
+	return fmt.Sprintf("epub-%08x", h.Sum32())
+}
+
+// addDocument adds the linked and embedded stylesheets of a content document not seen yet
+func (b *bookStyles) addDocument(doc *html.Node, r *zip.ReadCloser, contentFilePath string, manifestHrefMap map[string]Item) {
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "link":
+				if cssPath, ok := getStylesheetPath(n, contentFilePath); ok && !b.seen[cssPath] {
+					b.seen[cssPath] = true
+					if css, err := readZipFile(r, cssPath); err == nil {
+						b.css.WriteString(b.scopeCSS(string(toUTF8(css)), cssPath, r, manifestHrefMap))
+					}
+				}
+			case "style":
+				if media, _ := getAttr(n, "media"); mediaApplies(media) {
+					css := mergeStyleText(n)
+					if css != "" && !b.seen[css] {
+						b.seen[css] = true
+						b.css.WriteString(b.scopeCSS(css, contentFilePath, r, manifestHrefMap))
+					}
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+}
+
+// String returns the collected stylesheet, safe to place in a style element
+func (b *bookStyles) String() string {
+	return escapeStyleText(b.css.String())
+}
+
+// scopeCSS sanitizes css and scopes its rules under the book's container, url() references are
+// resolved against the stylesheet at basePath. @import, @page, animations and rules for other
+// media than the screen are left out
+func (b *bookStyles) scopeCSS(css string, basePath string, r *zip.ReadCloser, manifestHrefMap map[string]Item) string {
+	var out strings.Builder
+	b.writeRules(&out, cssCommentPattern.ReplaceAllString(css, ""), basePath, r, manifestHrefMap)
+	return out.String()
+}
+
+func (b *bookStyles) writeRules(out *strings.Builder, css string, basePath string, r *zip.ReadCloser, manifestHrefMap map[string]Item) {
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return
+		}
+		open := strings.IndexByte(css, '{')
+		if semicolon := strings.IndexByte(css, ';'); semicolon >= 0 && (open < 0 || semicolon < open) {
+			// @import, @charset, @namespace and stray semicolons
+			css = css[semicolon+1:]
+			continue
+		}
+		if open < 0 {
+			return
+		}
+		end := matchingBrace(css, open)
+		prelude := strings.TrimSpace(css[:open])
+		block := css[open+1 : end]
+		if end < len(css) {
+			css = css[end+1:]
+		} else {
+			css = ""
+		}
+
+		lowerPrelude := strings.ToLower(prelude)
+		switch {
+		case strings.HasPrefix(lowerPrelude, "@media"), strings.HasPrefix(lowerPrelude, "@supports"):
+			if strings.HasPrefix(lowerPrelude, "@media") && !mediaApplies(strings.TrimSpace(prelude[len("@media"):])) {
+				continue
+			}
+			var inner strings.Builder
+			b.writeRules(&inner, block, basePath, r, manifestHrefMap)
+			if inner.Len() > 0 {
+				out.WriteString(prelude + " {\n" + inner.String() + "}\n")
+			}
+		case strings.HasPrefix(lowerPrelude, "@font-face"):
+			if declarations := b.sanitizeDeclarations(block, basePath, r, manifestHrefMap); declarations != "" {
+				out.WriteString("@font-face { " + declarations + " }\n")
+			}
+		case strings.HasPrefix(lowerPrelude, "@"):
+			// @page, @keyframes and the like
+		default:
+			selectors := scopeSelectors(prelude, b.scope)
+			declarations := b.sanitizeDeclarations(block, basePath, r, manifestHrefMap)
+			if selectors != "" && declarations != "" {
+				out.WriteString(selectors + " { " + declarations + " }\n")
+			}
+		}
+	}
+}
+
+// sanitizeDeclarations drops the declarations the policy refuses and fixed positioning, which
+// would escape the container, and embeds the resources of the rest
+func (b *bookStyles) sanitizeDeclarations(block string, basePath string, r *zip.ReadCloser, manifestHrefMap map[string]Item) string {
+	var kept []string
+	for _, part := range splitCSS(block, ';') {
+		property, value, ok := strings.Cut(part, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if property == "" || value == "" || !b.policy.allowCSS(part) {
+			continue
+		}
+		if property == "position" && strings.HasPrefix(strings.ToLower(value), "fixed") {
+			continue
+		}
+		kept = append(kept, property+": "+rewriteCSSURLs(value, basePath, r, manifestHrefMap))
+	}
+	return strings.Join(kept, "; ")
+}
+
+// scopeSelectors prefixes every selector of a selector list with the scope class
+func scopeSelectors(prelude string, scope string) string {
+	var scoped []string
+	seen := make(map[string]bool)
+	for _, selector := range splitCSS(prelude, ',') {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+		for {
+			loc := rootCompoundPattern.FindStringIndex(selector)
+			if loc == nil || loc[1] == 0 {
+				break
+			}
+			selector = selector[loc[1]:]
+		}
+		selector = strings.TrimSpace("." + scope + " " + selector)
+		if !seen[selector] {
+			seen[selector] = true
+			scoped = append(scoped, selector)
+		}
+	}
+	return strings.Join(scoped, ", ")
+}
+
+// splitCSS splits s at the sep characters outside strings, parentheses and brackets
+func splitCSS(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			if depth > 0 {
+				depth--
+			}
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
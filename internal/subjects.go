@@ -0,0 +1,382 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// subject authorities recognised without an explicit authority refinement
+const (
+	AuthorityBISAC = "BISAC"
+	AuthorityThema = "THEMA"
+)
+
+var bisacCodePattern = regexp.MustCompile(`^[A-Z]{3}[0-9]{6}$`)
+
+type Subject struct {
+	Value     string // dc:subject as written
+	Authority string // upper case authority, e.g. "BISAC", empty for free text
+	Term      string // code within the authority, e.g. "FIC028000"
+}
+
+type Genre struct {
+	ID   int
+	Name string
+}
+
+// GenreMapper picks the application genre for a book from its subjects
+type GenreMapper interface {
+	MapGenre(subjects []Subject) (Genre, bool)
+}
+
+// getSubjects applies the authority and term refinements, and recognises bare BISAC codes and
+// "FICTION / Horror" style BISAC headings
+func getSubjects(elements []dcElement, metaMap map[string]map[string]Meta) []Subject {
+	var subjects []Subject
+	for _, element := range elements {
+		value := strings.TrimSpace(element.Text)
+		if value == "" {
+			continue
+		}
+		subject := Subject{
+			Value:     value,
+			Authority: strings.ToUpper(strings.TrimSpace(getMetadata(metaMap, element.Id, "authority"))),
+			Term:      strings.TrimSpace(getMetadata(metaMap, element.Id, "term")),
+		}
+		if subject.Authority == "" {
+			subject.Authority = strings.ToUpper(strings.TrimSpace(element.Authority))
+		}
+		if subject.Authority == "" {
+			subject.Authority = strings.ToUpper(strings.TrimSpace(element.Scheme))
+		}
+		switch {
+		case subject.Authority == "" && bisacCodePattern.MatchString(strings.ToUpper(value)):
+			subject.Authority = AuthorityBISAC
+			subject.Term = strings.ToUpper(value)
+		case subject.Authority == AuthorityBISAC && subject.Term == "" && bisacCodePattern.MatchString(strings.ToUpper(value)):
+			subject.Term = strings.ToUpper(value)
+		case subject.Authority == "" && isBisacHeading(value):
+			subject.Authority = AuthorityBISAC
+		}
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// isBisacHeading matches the upper case major heading of "FICTION / Science Fiction / General"
+func isBisacHeading(value string) bool {
+	parts := strings.Split(value, " / ")
+	return len(parts) > 1 && parts[0] == strings.ToUpper(parts[0]) && parts[0] != strings.ToLower(parts[0])
+}
+
+// TableGenreMapper maps coded subjects through code prefix tables and BISAC headings through a
+// heading table first, and all subjects through keywords second. The longest matching code
+// prefix or heading wins and keywords are tried in order, matching whole words
+type TableGenreMapper struct {
+	BISAC    map[string]string // BISAC code prefix to genre name
+	Thema    map[string]string // Thema code prefix to genre name
+	Headings map[string]string // upper case BISAC heading, e.g. "FICTION / HORROR", to genre name
+	Keywords []GenreKeyword    // tried in order against free text and headings
+	Genres   map[string]int    // genre name to application id
+}
+
+type GenreKeyword struct {
+	Keyword string
+	Genre   string
+}
+
+func (m TableGenreMapper) MapGenre(subjects []Subject) (Genre, bool) {
+	for _, subject := range subjects {
+		var table map[string]string
+		switch subject.Authority {
+		case AuthorityBISAC:
+			table = m.BISAC
+		case AuthorityThema:
+			table = m.Thema
+		default:
+			continue
+		}
+		code := subject.Term
+		if code == "" && subject.Authority == AuthorityBISAC {
+			// a heading, its text is no code
+			if name, ok := m.headingMatch(subject.Value); ok {
+				return m.genre(name), true
+			}
+			continue
+		}
+		if code == "" {
+			code = subject.Value
+		}
+		if name, ok := longestPrefixMatch(table, strings.ToUpper(code)); ok {
+			return m.genre(name), true
+		}
+	}
+	// every subject is tried for a keyword before falling back to a more generic one
+	words := make([][]string, len(subjects))
+	for i, subject := range subjects {
+		words[i] = getKeywordWords(subject.Value)
+	}
+	for _, keyword := range m.Keywords {
+		keywordWords := getKeywordWords(keyword.Keyword)
+		for i := range subjects {
+			if containsWords(words[i], keywordWords) {
+				return m.genre(keyword.Genre), true
+			}
+		}
+	}
+	return Genre{}, false
+}
+
+// getKeywordWords splits text into lower case words, "Children's stories -- Non-fiction" becomes
+// children, s, stories, non, fiction
+func getKeywordWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsWords reports whether words holds the keyword words in a row, whole words only so
+// "plays" doesn't match "Displays" nor "language" "Programming languages"
+func containsWords(words []string, keyword []string) bool {
+	if len(keyword) == 0 {
+		return false
+	}
+	for i := 0; i+len(keyword) <= len(words); i++ {
+		match := true
+		for j, word := range keyword {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// headingMatch looks up the most specific part of a heading the table has, "FICTION / Horror / General"
+// is tried as a whole, then as "FICTION / HORROR" and finally as "FICTION"
+func (m TableGenreMapper) headingMatch(heading string) (string, bool) {
+	parts := strings.Split(strings.ToUpper(heading), "/")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	for n := len(parts); n > 0; n-- {
+		if name, ok := m.Headings[strings.Join(parts[:n], " / ")]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (m TableGenreMapper) genre(name string) Genre {
+	return Genre{ID: m.Genres[name], Name: name}
+}
+
+func longestPrefixMatch(table map[string]string, code string) (string, bool) {
+	best, bestLen := "", 0
+	for prefix, name := range table {
+		if len(prefix) > bestLen && strings.HasPrefix(code, prefix) {
+			best, bestLen = name, len(prefix)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// genre names used by the default mapping
+const (
+	GenreFiction        = "Fiction"
+	GenreScienceFiction = "Science Fiction"
+	GenreFantasy        = "Fantasy"
+	GenreHorror         = "Horror"
+	GenreMystery        = "Mystery"
+	GenreThriller       = "Thriller"
+	GenreRomance        = "Romance"
+	GenreHistorical     = "Historical Fiction"
+	GenreAdventure      = "Adventure"
+	GenreChildren       = "Children"
+	GenreYoungAdult     = "Young Adult"
+	GenrePoetry         = "Poetry"
+	GenreDrama          = "Drama"
+	GenreBiography      = "Biography"
+	GenreHistory        = "History"
+	GenreScience        = "Science"
+	GenreLanguage       = "Language Learning"
+	GenreNonFiction     = "Non-fiction"
+)
+
+// DefaultGenreMapper is used when no mapper is configured
+var DefaultGenreMapper = TableGenreMapper{
+	BISAC: map[string]string{
+		"FIC":    GenreFiction,
+		"FIC002": GenreAdventure,
+		"FIC009": GenreFantasy,
+		"FIC014": GenreHistorical,
+		"FIC015": GenreHorror,
+		"FIC022": GenreMystery,
+		"FIC027": GenreRomance,
+		"FIC028": GenreScienceFiction,
+		"FIC030": GenreThriller,
+		"FIC031": GenreThriller,
+		"JUV":    GenreChildren,
+		"JNF":    GenreChildren,
+		"YAF":    GenreYoungAdult,
+		"YAN":    GenreYoungAdult,
+		"POE":    GenrePoetry,
+		"DRA":    GenreDrama,
+		"BIO":    GenreBiography,
+		"HIS":    GenreHistory,
+		"SCI":    GenreScience,
+		"FOR":    GenreLanguage,
+		"LAN":    GenreLanguage,
+		"ART":    GenreNonFiction,
+		"BUS":    GenreNonFiction,
+		"CKB":    GenreNonFiction,
+		"COM":    GenreNonFiction,
+		"EDU":    GenreNonFiction,
+		"HEA":    GenreNonFiction,
+		"LAW":    GenreNonFiction,
+		"MAT":    GenreNonFiction,
+		"MED":    GenreNonFiction,
+		"MUS":    GenreNonFiction,
+		"NAT":    GenreNonFiction,
+		"PHI":    GenreNonFiction,
+		"POL":    GenreNonFiction,
+		"PSY":    GenreNonFiction,
+		"REL":    GenreNonFiction,
+		"SEL":    GenreNonFiction,
+		"SOC":    GenreNonFiction,
+		"TEC":    GenreNonFiction,
+		"TRU":    GenreNonFiction,
+		"TRV":    GenreNonFiction,
+	},
+	Thema: map[string]string{
+		"F":  GenreFiction,
+		"FF": GenreMystery,
+		"FH": GenreThriller,
+		"FJ": GenreAdventure,
+		"FK": GenreHorror,
+		"FL": GenreScienceFiction,
+		"FM": GenreFantasy,
+		"FR": GenreRomance,
+		"FV": GenreHistorical,
+		"YF": GenreYoungAdult,
+		"YB": GenreChildren,
+		"DC": GenrePoetry,
+		"DD": GenreDrama,
+		"DN": GenreBiography,
+		"NH": GenreHistory,
+		"P":  GenreScience,
+		"CJ": GenreLanguage,
+		"A":  GenreNonFiction,
+		"G":  GenreNonFiction,
+		"J":  GenreNonFiction,
+		"K":  GenreNonFiction,
+		"L":  GenreNonFiction,
+		"Q":  GenreNonFiction,
+		"R":  GenreNonFiction,
+		"S":  GenreNonFiction,
+		"T":  GenreNonFiction,
+		"U":  GenreNonFiction,
+		"V":  GenreNonFiction,
+		"W":  GenreNonFiction,
+	},
+	Headings: map[string]string{
+		"FICTION":                       GenreFiction,
+		"FICTION / ACTION & ADVENTURE":  GenreAdventure,
+		"FICTION / FANTASY":             GenreFantasy,
+		"FICTION / HISTORICAL":          GenreHistorical,
+		"FICTION / HORROR":              GenreHorror,
+		"FICTION / GHOST":               GenreHorror,
+		"FICTION / MYSTERY & DETECTIVE": GenreMystery,
+		"FICTION / ROMANCE":             GenreRomance,
+		"FICTION / SCIENCE FICTION":     GenreScienceFiction,
+		"FICTION / SUSPENSE":            GenreThriller,
+		"FICTION / THRILLERS":           GenreThriller,
+		"JUVENILE FICTION":              GenreChildren,
+		"JUVENILE NONFICTION":           GenreChildren,
+		"YOUNG ADULT FICTION":           GenreYoungAdult,
+		"YOUNG ADULT NONFICTION":        GenreYoungAdult,
+		"POETRY":                        GenrePoetry,
+		"DRAMA":                         GenreDrama,
+		"BIOGRAPHY & AUTOBIOGRAPHY":     GenreBiography,
+		"HISTORY":                       GenreHistory,
+		"SCIENCE":                       GenreScience,
+		"FOREIGN LANGUAGE STUDY":        GenreLanguage,
+		"LANGUAGE ARTS & DISCIPLINES":   GenreLanguage,
+		"ART":                           GenreNonFiction,
+		"BUSINESS & ECONOMICS":          GenreNonFiction,
+		"COOKING":                       GenreNonFiction,
+		"COMPUTERS":                     GenreNonFiction,
+		"EDUCATION":                     GenreNonFiction,
+		"HEALTH & FITNESS":              GenreNonFiction,
+		"LAW":                           GenreNonFiction,
+		"MATHEMATICS":                   GenreNonFiction,
+		"MEDICAL":                       GenreNonFiction,
+		"MUSIC":                         GenreNonFiction,
+		"NATURE":                        GenreNonFiction,
+		"PHILOSOPHY":                    GenreNonFiction,
+		"POLITICAL SCIENCE":             GenreNonFiction,
+		"PSYCHOLOGY":                    GenreNonFiction,
+		"RELIGION":                      GenreNonFiction,
+		"SELF-HELP":                     GenreNonFiction,
+		"SOCIAL SCIENCE":                GenreNonFiction,
+		"TECHNOLOGY & ENGINEERING":      GenreNonFiction,
+		"TRAVEL":                        GenreNonFiction,
+		"TRUE CRIME":                    GenreNonFiction,
+	},
+	Keywords: []GenreKeyword{
+		{"science fiction", GenreScienceFiction},
+		{"fantasy", GenreFantasy},
+		{"horror", GenreHorror},
+		{"ghost stories", GenreHorror},
+		{"detective", GenreMystery},
+		{"mystery", GenreMystery},
+		{"thriller", GenreThriller},
+		{"thrillers", GenreThriller},
+		{"suspense", GenreThriller},
+		{"love stories", GenreRomance},
+		{"romance", GenreRomance},
+		{"historical fiction", GenreHistorical},
+		{"adventure", GenreAdventure},
+		{"juvenile", GenreChildren},
+		{"children", GenreChildren},
+		{"young adult", GenreYoungAdult},
+		{"poetry", GenrePoetry},
+		{"drama", GenreDrama},
+		{"plays", GenreDrama},
+		{"biography", GenreBiography},
+		{"autobiography", GenreBiography},
+		{"readers", GenreLanguage},
+		{"language", GenreLanguage},
+		// before fiction, which it contains
+		{"nonfiction", GenreNonFiction},
+		{"non-fiction", GenreNonFiction},
+		{"fiction", GenreFiction},
+		{"history", GenreHistory},
+		{"science", GenreScience},
+	},
+	Genres: map[string]int{
+		GenreFiction:        1,
+		GenreScienceFiction: 2,
+		GenreFantasy:        3,
+		GenreHorror:         4,
+		GenreMystery:        5,
+		GenreThriller:       6,
+		GenreRomance:        7,
+		GenreHistorical:     8,
+		GenreAdventure:      9,
+		GenreChildren:       10,
+		GenreYoungAdult:     11,
+		GenrePoetry:         12,
+		GenreDrama:          13,
+		GenreBiography:      14,
+		GenreHistory:        15,
+		GenreScience:        16,
+		GenreLanguage:       17,
+		GenreNonFiction:     18,
+	},
+}
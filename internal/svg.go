@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// getSVGWrappedImage returns the image of an svg element holding nothing but a single image and
+// its title or description, the way covers and title pages wrap a picture to scale it
+func getSVGWrappedImage(svg *html.Node) (*html.Node, bool) {
+	var image *html.Node
+	for c := svg.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return nil, false
+			}
+		case html.ElementNode:
+			switch c.Data {
+			case "image":
+				if image != nil {
+					return nil, false
+				}
+				image = c
+			case "title", "desc":
+			default:
+				return nil, false
+			}
+		}
+	}
+	return image, image != nil
+}
+
+// svgImageToImg builds the img element standing in for an svg image wrapper, its alt text taken
+// from the svg title, description or label
+func svgImageToImg(svg *html.Node, image *html.Node) *html.Node {
+	img := &html.Node{Type: html.ElementNode, Data: "img", DataAtom: atom.Img}
+	img.Attr = append(img.Attr, html.Attribute{Key: "src", Val: getSVGImageHref(image)})
+	alt := ""
+	for _, n := range []*html.Node{svg, image} {
+		if label, ok := getAttr(n, "aria-label"); ok && alt == "" {
+			alt = strings.TrimSpace(label)
+		}
+	}
+	for c := svg.FirstChild; c != nil && alt == ""; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.Data == "title" || c.Data == "desc") {
+			alt = strings.Join(strings.Fields(getTextContent(c)), " ")
+		}
+	}
+	img.Attr = append(img.Attr, html.Attribute{Key: "alt", Val: alt})
+	if id, ok := getAttr(svg, "id"); ok {
+		img.Attr = append(img.Attr, html.Attribute{Key: "id", Val: id})
+	}
+	return img
+}
+
+func getTextContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(getTextContent(c))
+	}
+	return b.String()
+}
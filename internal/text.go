@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// elements that start a new line in the plain text output
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "li": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// LanguageSpan marks the byte range [Start, End) of Content.Text written in Lang
+type LanguageSpan struct {
+	Start int
+	End   int
+	Lang  string
+}
+
+// textWriter collapses whitespace as it goes so span offsets point into the final text
+type textWriter struct {
+	b              strings.Builder
+	pendingSpace   bool
+	pendingNewline bool
+	spans          []LanguageSpan
+}
+
+func (w *textWriter) newline() {
+	w.pendingNewline = true
+}
+
+func (w *textWriter) writeText(text string, lang string) {
+	if text == "" {
+		return
+	}
+	if isHTMLSpace(text[0]) {
+		w.pendingSpace = true
+	}
+	for _, word := range strings.Fields(text) {
+		if w.b.Len() > 0 {
+			if w.pendingNewline {
+				w.b.WriteString("\n")
+			} else if w.pendingSpace {
+				w.b.WriteString(" ")
+			}
+		}
+		w.pendingNewline, w.pendingSpace = false, false
+
+		start := w.b.Len()
+		w.b.WriteString(word)
+		if last := len(w.spans) - 1; last >= 0 && w.spans[last].Lang == lang {
+			w.spans[last].End = w.b.Len()
+		} else {
+			w.spans = append(w.spans, LanguageSpan{Start: start, End: w.b.Len(), Lang: lang})
+		}
+		w.pendingSpace = true
+	}
+	if !isHTMLSpace(text[len(text)-1]) {
+		w.pendingSpace = false
+	}
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// getLangAttr returns the xml:lang or lang attribute of n, xml:lang wins as it does in XHTML
+func getLangAttr(n *html.Node) (string, bool) {
+	lang, found := "", false
+	for _, attr := range n.Attr {
+		switch {
+		case attr.Key == "xml:lang" || (attr.Namespace == "xml" && attr.Key == "lang"):
+			return normalizeLangAttr(attr.Val), true
+		case attr.Key == "lang" && attr.Namespace == "":
+			lang, found = normalizeLangAttr(attr.Val), true
+		}
+	}
+	return lang, found
+}
+
+func normalizeLangAttr(value string) string {
+	if tag, ok := normalizeLanguage(value); ok {
+		return tag.String()
+	}
+	return strings.TrimSpace(value)
+}
+
+// getDocumentLanguage returns the language declared on the html or body element of doc
+func getDocumentLanguage(doc *html.Node) string {
+	lang := ""
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "html" || n.Data == "body") {
+			if l, ok := getLangAttr(n); ok {
+				lang = l
+			}
+			if n.Data == "body" {
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	return lang
+}
+
+// extractPlainText returns the text of the body of doc with one line per block element,
+// together with the spans of text in each effective language
+func extractPlainText(doc *html.Node, lang string) (string, []LanguageSpan) {
+	w := &textWriter{}
+	var walk func(*html.Node, string)
+	walk = func(n *html.Node, lang string) {
+		switch n.Type {
+		case html.TextNode:
+			w.writeText(n.Data, lang)
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "script", "style", "link", "meta", "head", "title", "svg":
+				return
+			case "audio", "video":
+				// the fallback content is for reading systems that can't play the media
+				return
+			case "math":
+				w.writeText(getMathText(n), lang)
+				return
+			}
+			if l, ok := getLangAttr(n); ok {
+				lang = l
+			}
+			if blockElements[n.Data] {
+				w.newline()
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, lang)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			w.newline()
+		}
+	}
+	walk(doc, lang)
+	return w.b.String(), w.spans
+}
+
+// applyBookLanguage fills in the language of chapters and spans that don't declare one
+func applyBookLanguage(texts []Content, lang string) {
+	for i := range texts {
+		if texts[i].Language == "" {
+			texts[i].Language = lang
+		}
+		var spans []LanguageSpan
+		for _, span := range texts[i].LanguageSpans {
+			if span.Lang == "" {
+				span.Lang = lang
+			}
+			if last := len(spans) - 1; last >= 0 && spans[last].Lang == span.Lang {
+				spans[last].End = span.End
+				continue
+			}
+			spans = append(spans, span)
+		}
+		texts[i].LanguageSpans = spans
+	}
+}
@@ -0,0 +1,64 @@
+package parser
+
+import "strings"
+
+// title-type refinement values defined by EPUB 3
+const (
+	TitleTypeMain       = "main"
+	TitleTypeSubtitle   = "subtitle"
+	TitleTypeShort      = "short"
+	TitleTypeCollection = "collection"
+	TitleTypeEdition    = "edition"
+	TitleTypeExpanded   = "expanded"
+)
+
+// getTitleParts picks the main title, subtitle and sort title from the refined titles.
+// Without a "main" title-type the first title is the main one, as the EPUB 3 spec says.
+// bookLanguage is the language of titles without an xml:lang of their own
+func getTitleParts(titles *[]Title, bookLanguage string) (main Title, subtitle string, sortTitle string) {
+	if titles == nil || len(*titles) == 0 {
+		return Title{}, "", ""
+	}
+	list := *titles
+	main = list[0]
+	for _, t := range list {
+		if t.Type == TitleTypeMain {
+			main = t
+			break
+		}
+	}
+	for _, t := range list {
+		if t.Type == TitleTypeSubtitle && t.Title != main.Title {
+			subtitle = t.Title
+			break
+		}
+	}
+
+	sortTitle = main.FileAs
+	if sortTitle == "" {
+		titleLanguage := main.Language
+		if titleLanguage == "" {
+			titleLanguage = bookLanguage
+		}
+		sortTitle = deriveSortTitle(main.Title, titleLanguage)
+	}
+	return main, subtitle, sortTitle
+}
+
+// deriveSortTitle moves a leading English article to the end, "The Hobbit" becomes "Hobbit, The".
+// Only English titles get one, "A" or "The" can start a title in other languages too
+func deriveSortTitle(title string, lang string) string {
+	tag, ok := normalizeLanguage(lang)
+	if !ok {
+		return ""
+	}
+	if base, _ := tag.Base(); base.String() != "en" {
+		return ""
+	}
+	for _, article := range []string{"The ", "A ", "An "} {
+		if len(title) > len(article) && strings.EqualFold(title[:len(article)], article) {
+			return strings.TrimSpace(title[len(article):]) + ", " + strings.TrimSpace(title[:len(article)])
+		}
+	}
+	return title
+}
@@ -0,0 +1,166 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	xhtmlNamespace  = "http://www.w3.org/1999/xhtml"
+	svgNamespace    = "http://www.w3.org/2000/svg"
+	mathMLNamespace = "http://www.w3.org/1998/Math/MathML"
+	xmlNamespace    = "http://www.w3.org/XML/1998/namespace"
+)
+
+// parseContentDocument transcodes a content document to UTF-8 and parses XHTML and SVG as XML so
+// self-closing elements, CDATA sections and processing instructions keep their meaning. Other
+// media types and XHTML that isn't well-formed go through the HTML parser
+func parseContentDocument(data []byte, mediaType string) (*html.Node, error) {
+	data = toUTF8(data)
+	switch mediaType {
+	case "application/xhtml+xml", "image/svg+xml":
+		if doc, err := parseXHTML(data); err == nil {
+			return doc, nil
+		}
+	}
+	return html.Parse(bytes.NewReader(data))
+}
+
+// parseXHTML builds the same kind of tree html.Parse does from a well-formed XML document:
+// namespaces become the html.Node namespaces ("", "svg" or "math") and attributes keep the
+// prefix they were written with. data is UTF-8 already, see toUTF8
+func parseXHTML(data []byte) (*html.Node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Entity = xml.HTMLEntity
+	// the declared encoding describes the bytes before toUTF8 transcoded them
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	doc := &html.Node{Type: html.DocumentNode}
+	current := doc
+	// prefixes maps a namespace URL back to the prefix it was declared with, one map per open element
+	prefixes := []map[string]string{{xmlNamespace: "xml"}}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string, len(prefixes[len(prefixes)-1]))
+			for url, prefix := range prefixes[len(prefixes)-1] {
+				scope[url] = prefix
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					scope[attr.Value] = attr.Name.Local
+				}
+			}
+			prefixes = append(prefixes, scope)
+
+			n := &html.Node{Type: html.ElementNode}
+			switch t.Name.Space {
+			case "", xhtmlNamespace:
+				n.Data = t.Name.Local
+				n.DataAtom = atom.Lookup([]byte(n.Data))
+			case svgNamespace:
+				n.Data, n.Namespace = t.Name.Local, "svg"
+			case mathMLNamespace:
+				n.Data, n.Namespace = t.Name.Local, "math"
+			default:
+				// e.g. epub:switch, which the HTML parser also sees as an element named with its prefix
+				n.Data = qualifiedName(scope, t.Name)
+			}
+			for _, attr := range t.Attr {
+				n.Attr = append(n.Attr, xhtmlAttribute(n, scope, attr))
+			}
+			current.AppendChild(n)
+			current = n
+		case xml.EndElement:
+			prefixes = prefixes[:len(prefixes)-1]
+			if current.Parent != nil {
+				current = current.Parent
+			}
+		case xml.CharData:
+			if current == doc {
+				// whitespace around the root element
+				continue
+			}
+			if last := current.LastChild; last != nil && last.Type == html.TextNode {
+				last.Data += string(t)
+				continue
+			}
+			current.AppendChild(&html.Node{Type: html.TextNode, Data: string(t)})
+		case xml.Comment:
+			current.AppendChild(&html.Node{Type: html.CommentNode, Data: string(t)})
+		case xml.Directive:
+			if fields := strings.Fields(string(t)); len(fields) > 1 && strings.EqualFold(fields[0], "doctype") {
+				doc.AppendChild(&html.Node{Type: html.DoctypeNode, Data: strings.ToLower(fields[1])})
+			}
+		}
+	}
+	return wrapDocumentRoot(doc), nil
+}
+
+func qualifiedName(scope map[string]string, name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	if prefix, ok := scope[name.Space]; ok {
+		return prefix + ":" + name.Local
+	}
+	// undeclared prefixes are left as the decoder found them
+	return name.Space + ":" + name.Local
+}
+
+// xhtmlAttribute names attr the way the HTML parser would: xlink and xml attributes of foreign
+// elements are namespaced, every other prefixed attribute keeps its prefix in the key
+func xhtmlAttribute(n *html.Node, scope map[string]string, attr xml.Attr) html.Attribute {
+	switch {
+	case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+		return html.Attribute{Key: "xmlns", Val: attr.Value}
+	case attr.Name.Space == "xmlns":
+		if n.Namespace != "" && attr.Name.Local == "xlink" {
+			return html.Attribute{Namespace: "xmlns", Key: "xlink", Val: attr.Value}
+		}
+		return html.Attribute{Key: "xmlns:" + attr.Name.Local, Val: attr.Value}
+	}
+	key := qualifiedName(scope, attr.Name)
+	if n.Namespace != "" {
+		if prefix, local, ok := strings.Cut(key, ":"); ok && (prefix == "xlink" || prefix == "xml") {
+			return html.Attribute{Namespace: prefix, Key: local, Val: attr.Value}
+		}
+	}
+	return html.Attribute{Key: key, Val: attr.Value}
+}
+
+// wrapDocumentRoot gives documents whose root isn't html, such as SVG content documents, the
+// html, head and body elements the rest of the parser expects, the way html.Parse does
+func wrapDocumentRoot(doc *html.Node) *html.Node {
+	var root *html.Node
+	for c := doc.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			root = c
+		}
+	}
+	if root == nil || (root.Namespace == "" && root.Data == "html") {
+		return doc
+	}
+	doc.RemoveChild(root)
+	htmlNode := &html.Node{Type: html.ElementNode, Data: "html", DataAtom: atom.Html}
+	htmlNode.AppendChild(&html.Node{Type: html.ElementNode, Data: "head", DataAtom: atom.Head})
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	body.AppendChild(root)
+	htmlNode.AppendChild(body)
+	doc.AppendChild(htmlNode)
+	return doc
+}